@@ -1,91 +1,520 @@
 // Package adaptconf adapts registered configurables to configuration file
 // formats.
+//
+// By default, values in a configuration file which cannot be set, for example
+// because they are of the wrong type, are reported via provenance.Warnf and
+// otherwise ignored, so that one bad value does not prevent a program from
+// starting. Set StrictValues to make loading fail instead. Reload always
+// reports such values as errors.
 package adaptconf
 
 import "os"
 import "fmt"
 import "strings"
+import "sync"
+import "reflect"
 import "path/filepath"
 import "gopkg.in/hlandau/configurable.v1"
 import "gopkg.in/hlandau/easyconfig.v1/cflag"
+import "gopkg.in/hlandau/easyconfig.v1/manual"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
 import "gopkg.in/hlandau/svcutils.v1/exepath"
-import "github.com/BurntSushi/toml"
 
 var confFlag = cflag.String(nil, "conf", "", "Configuration file path")
 var lastConfPath string
-
+var confPaths []string
+
+// If true, LoadPaths loads every candidate path which exists rather than only
+// the one with the highest precedence. Files are loaded in order of increasing
+// precedence, so a value set in a later file overrides the same value set in
+// an earlier one, while values the later file does not mention are left as
+// the earlier file set them.
+var Layered bool
+
+// If true, LoadPath, LoadPaths and Load return an error if some values cannot
+// be set, for example because they are of the wrong type. The remaining values
+// are still applied. If false, such values are reported via provenance.Warnf.
+var StrictValues bool
+
+// Serializes loading and reloading. lastSpec and lastAssignments describe the
+// most recent successful load so that it can be repeated by Reload.
+var loadMu sync.Mutex
+var lastSpec *loadSpec
+var lastAssignments map[configurable.Configurable]*assignment
+
+// What the most recent call to Reload changed, so that Rollback can undo it.
+var lastUndo *undo
+
+// Returns the path of the last configuration file loaded.
+//
+// Deprecated: In layered mode more than one file may contribute; use
+// ConfPaths.
 func LastConfPath() string {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
 	return lastConfPath
 }
 
+// Returns the paths of all files which contributed to the configuration
+// during the most recent call to LoadPath, LoadPaths or Load, in the order
+// in which they were applied.
+func ConfPaths() []string {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	return append([]string(nil), confPaths...)
+}
+
+// Loads the configuration file at confFilePath, followed by any drop-in
+// fragments in the directory given by DropInDir (by default, confFilePath
+// with ".d" appended). Fragments are loaded in lexical order of file name, so
+// later fragments override earlier ones, and may be in any format registered
+// with RegisterFormat. Files matching DropInIgnore are skipped.
+//
+// It is not an error for either the file or the drop-in directory to be
+// missing, but it is an error for both to be missing.
+//
+// Each file may include others using the key named by IncludeKey.
+//
+// All files are read and checked before any values are applied, so if a file
+// cannot be read or decoded, no values are applied. If some values cannot be
+// set, for example because they are of the wrong type, the remaining values
+// are still applied; see StrictValues.
 func LoadPath(confFilePath string) error {
+	return load(&loadSpec{path: confFilePath})
+}
+
+// Loads configuration from the candidate paths given, which are listed in
+// order of increasing precedence. Paths beginning with "$BIN/" are relative
+// to the directory containing the executable, and paths beginning with "~/"
+// are relative to the user's home directory.
+//
+// If the -conf flag has been set, its value is loaded instead and the
+// candidates are ignored. Otherwise, if Layered is set, every candidate which
+// exists is loaded in turn; if it is not, only the last candidate which
+// exists is loaded.
+func LoadPaths(paths []string) error {
+	return load(&loadSpec{paths: paths})
+}
+
+// Describes what to load: either a single configuration file path, or a list
+// of candidate paths.
+type loadSpec struct {
+	path  string
+	paths []string
+}
+
+// Returns the configuration file paths to be loaded, after expansion.
+func (spec *loadSpec) mainPaths() []string {
+	if spec.path != "" {
+		return []string{spec.path}
+	}
+
+	confPath := confFlag.Value()
+	if confPath != "" {
+		return []string{confPath}
+	}
+
+	var mains []string
+	for _, path := range spec.paths {
+		path = expandPath(path)
+
+		if !pathExists(path) {
+			continue
+		}
+
+		mains = append(mains, path)
+	}
+
+	if !Layered && len(mains) > 1 {
+		mains = mains[len(mains)-1:]
+	}
+
+	return mains
+}
+
+// A decoded configuration file.
+type document struct {
+	// The path of the file.
+	path string
+
+	// The configuration file path which led to this file being loaded. This
+	// differs from path for drop-in fragments and included files.
+	main string
+
+	m map[string]interface{}
+
+	// Line numbers of keys, where known. See LineFinder.
+	lines map[string]int
+}
+
+func (spec *loadSpec) read() ([]document, error) {
+	var docs []document
+	for _, mainPath := range spec.mainPaths() {
+		var err error
+		docs, err = readPath(mainPath, docs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return docs, nil
+}
+
+func load(spec *loadSpec) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	docs, err := spec.read()
+	if err != nil {
+		return err
+	}
+
+	lastSpec = spec
+	lastUndo = nil
+	_, _, errs := commit(docs, nil, nil)
+	if !StrictValues {
+		for _, err := range errs {
+			provenance.Warnf("%v", err)
+		}
+		return nil
+	}
+
+	return joinErrors(errs)
+}
+
+// Combines the errors encountered setting values into one, or returns nil if
+// there are none.
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// Records the documents as the current configuration and applies their
+// values. If prev is non-nil, this is a reload: only values which differ from
+// those in prev are applied, and configurables which require a restart are
+// not changed. Returns the assignments which were applied, the keys which
+// were not applied because they require a restart, and any errors encountered
+// setting values. If u is non-nil, the state which is changed is saved to it.
+func commit(docs []document, prev map[configurable.Configurable]*assignment, u *undo) (changed []*assignment, restart []string, errs []error) {
+	if u != nil {
+		u.assignments = prev
+		u.confPaths = confPaths
+		u.lastConfPath = lastConfPath
+	}
+
+	confPaths = nil
+	lastConfPath = ""
+	for _, doc := range docs {
+		confPaths = append(confPaths, doc.path)
+		lastConfPath = doc.main
+	}
+
+	cur, order := collect(docs)
+	lastAssignments = cur
+
+	for _, c := range order {
+		a := cur[c]
+		pa, ok := prev[c]
+		if ok && reflect.DeepEqual(pa.value, a.value) {
+			continue
+		}
+
+		u.save(c)
+		if prev != nil && restartRequired(c) {
+			if configWins(c) {
+				restart = append(restart, a.key)
+			}
+			record(c, a, false)
+
+			// Continue to compare against the value actually in effect.
+			if ok {
+				cur[c] = pa
+			} else {
+				delete(cur, c)
+			}
+			continue
+		}
+
+		set, err := setValue(c, a.value)
+		if err != nil {
+			if provenance.IsSecret(c) {
+				// The error may quote the value.
+				err = fmt.Errorf("invalid value")
+			}
+			errs = append(errs, fmt.Errorf("Error setting %s from %s: %s", a.key, a.file, err))
+		}
+		if set {
+			changed = append(changed, a)
+		}
+		record(c, a, set)
+	}
+
+	// Values which were previously set from configuration but are no longer
+	// present revert to their defaults.
+	for c, pa := range prev {
+		if _, ok := cur[c]; ok {
+			continue
+		}
+
+		if restartRequired(c) {
+			if configWins(c) {
+				restart = append(restart, pa.key)
+				cur[c] = pa
+			}
+			continue
+		}
+
+		u.save(c)
+		if resetValue(c) {
+			changed = append(changed, &assignment{key: pa.key})
+			dflt, _ := defaultValue(c)
+			provenance.Record(c, provenance.Source{
+				Kind:    provenance.Default,
+				Value:   dflt,
+				Applied: true,
+			})
+		}
+	}
+
+	return
+}
+
+// The state before a reload, for undoing it.
+type undo struct {
+	assignments  map[configurable.Configurable]*assignment
+	confPaths    []string
+	lastConfPath string
+	saved        map[configurable.Configurable]*savedState
+}
+
+// The state of a configurable before a reload.
+type savedState struct {
+	value    interface{}
+	priority configurable.Priority
+	history  []provenance.Source
+}
+
+// Saves the state of c, unless it has already been saved.
+func (u *undo) save(c configurable.Configurable) {
+	if u == nil {
+		return
+	}
+
+	if _, ok := u.saved[c]; ok {
+		return
+	}
+
+	s := &savedState{
+		history: provenance.History(c),
+	}
+	s.value, _ = value(c)
+	if cp, ok := c.(interface {
+		CfGetPriority() configurable.Priority
+	}); ok {
+		s.priority = cp.CfGetPriority()
+	}
+
+	u.saved[c] = s
+}
+
+// Undoes the most recent call to Reload, for example because the resulting
+// configuration failed validation. The values it set are restored along with
+// their priorities and provenance, and the next call to Reload compares the
+// configuration files with the configuration loaded before it, so that values
+// which are still present are applied again.
+//
+// Does nothing if Reload has not been called since the last call to Rollback,
+// LoadPath, LoadPaths or Load, or if the most recent call to Reload failed to
+// read the configuration files.
+func Rollback() {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	u := lastUndo
+	if u == nil {
+		return
+	}
+
+	lastUndo = nil
+	lastAssignments = u.assignments
+	confPaths = u.confPaths
+	lastConfPath = u.lastConfPath
+
+	for c, s := range u.saved {
+		if csv, ok := c.(interface {
+			CfSetValue(x interface{}) error
+		}); ok && s.value != nil {
+			csv.CfSetValue(s.value)
+		}
+
+		if cp, ok := c.(interface {
+			CfSetPriority(priority configurable.Priority)
+		}); ok {
+			cp.CfSetPriority(s.priority)
+		}
+
+		provenance.Reset(c, s.history)
+	}
+}
+
+func readPath(confFilePath string, docs []document) ([]document, error) {
 	paths := []string{}
 
 	_, mainStatErr := os.Stat(confFilePath)
 	if mainStatErr == nil {
 		paths = append(paths, confFilePath)
 	}
-	_, globStatErr := os.Stat(confFilePath + ".d")
-	if globStatErr == nil {
-		globResult, err := filepath.Glob(confFilePath + ".d/*.conf")
-		if err != nil {
-			return fmt.Errorf("Globbing error: %s", err)
-		}
-		paths = append(paths, globResult...)
+	dropIns, dropInErr := dropInPaths(confFilePath)
+	if dropInErr != nil && !os.IsNotExist(dropInErr) {
+		return nil, fmt.Errorf("Error reading drop-in directory: %s", dropInErr)
+	}
+	paths = append(paths, dropIns...)
+	if mainStatErr != nil && dropInErr != nil {
+		return nil, fmt.Errorf("Error finding conf file: %s, %s", mainStatErr, dropInErr)
 	}
-	if mainStatErr != nil && globStatErr != nil {
-		return fmt.Errorf("Error finding conf file: %s, %s", mainStatErr, globStatErr)
+	if mainStatErr != nil && DropInDir(confFilePath) == "" {
+		return nil, fmt.Errorf("Error finding conf file: %s", mainStatErr)
 	}
 
 	for _, path := range paths {
-		var m map[string]interface{}
-		_, err := toml.DecodeFile(path, &m)
+		var err error
+		docs, err = readFile(path, confFilePath, nil, docs)
 		if err != nil {
-			return fmt.Errorf("Error decoding %s: %s", path, err)
+			return nil, err
 		}
+	}
 
-		lastConfPath = confFilePath
+	return docs, nil
+}
 
-		configurable.Visit(func(c configurable.Configurable) error {
-			applyChild(c, m)
-			return nil
-		})
+// Reads a single file, after first reading any files it includes, and appends
+// them to docs. chain is the list of files which led to this one being
+// included.
+func readFile(path, mainPath string, chain []string, docs []document) ([]document, error) {
+	m, lines, err := decodeFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding %s: %s", path, err)
 	}
 
-	return nil
-}
+	docs, err = readIncludes(path, mainPath, m, chain, docs)
+	if err != nil {
+		return nil, err
+	}
 
-func LoadPaths(paths []string) error {
-	confPath := confFlag.Value()
+	m, err = interpolate(path, m, docs)
+	if err != nil {
+		return nil, err
+	}
 
-	if confPath == "" {
-		for _, path := range paths {
-			path = expandPath(path)
+	err = checkUnknownKeys(path, m)
+	if err != nil {
+		return nil, err
+	}
 
-			if !pathExists(path) {
-				continue
-			}
+	return append(docs, document{path: path, main: mainPath, m: m, lines: lines}), nil
+}
 
-			confPath = path
-		}
+func decodeFile(path string) (map[string]interface{}, map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if confPath == "" {
-		return nil
+	f := formatForPath(path)
+	m, err := f.Decode(data)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return LoadPath(confPath)
+	normalizeNumbers(m)
+
+	var lines map[string]int
+	if lf, ok := f.(LineFinder); ok {
+		lines = lf.KeyLines(data)
+	}
+
+	return m, lines, nil
 }
 
+// Loads configuration for the named program from the standard locations
+// returned by SearchPaths.
 func Load(programName string) error {
-	return LoadPaths([]string{
+	return LoadPaths(SearchPaths(programName))
+}
+
+// Returns the standard locations searched for the named program's
+// configuration file, in order of increasing precedence: the system
+// configuration directory, the XDG system configuration directories, the
+// user's XDG configuration directory, the working directory's etc directory,
+// and paths relative to the executable.
+//
+// The XDG directories are determined from $XDG_CONFIG_DIRS and
+// $XDG_CONFIG_HOME, falling back to /etc/xdg and $HOME/.config respectively
+// if these are unset.
+func SearchPaths(programName string) []string {
+	paths := []string{
 		fmt.Sprintf("/etc/%s/%s.conf", programName, programName),
 		fmt.Sprintf("/etc/%s.conf", programName),
+	}
+
+	// $XDG_CONFIG_DIRS is in order of decreasing importance.
+	dirs := xdgConfigDirs()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		paths = append(paths, filepath.Join(dirs[i], programName, programName+".conf"))
+	}
+
+	if home := xdgConfigHome(); home != "" {
+		paths = append(paths, filepath.Join(home, programName, programName+".conf"))
+	}
+
+	return append(paths,
 		fmt.Sprintf("etc/%s.conf", programName),
 		fmt.Sprintf("$BIN/%s.conf", programName),
 		fmt.Sprintf("$BIN/../etc/%s/%s.conf", programName, programName),
 		fmt.Sprintf("$BIN/../etc/%s.conf", programName),
-	})
+	)
+}
+
+// The XDG Base Directory Specification requires relative paths in these
+// variables to be ignored.
+func xdgConfigHome() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+
+	home := os.Getenv("HOME")
+	if !filepath.IsAbs(home) {
+		return ""
+	}
+
+	return filepath.Join(home, ".config")
+}
+
+func xdgConfigDirs() []string {
+	v := os.Getenv("XDG_CONFIG_DIRS")
+	if v == "" {
+		return []string{"/etc/xdg"}
+	}
+
+	var dirs []string
+	for _, dir := range filepath.SplitList(v) {
+		if filepath.IsAbs(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
 }
 
 func pathExists(path string) bool {
@@ -94,38 +523,111 @@ func pathExists(path string) bool {
 		return true
 	}
 
-	_, err = os.Stat(path + ".d")
-	if err == nil {
-		return true
+	dir := DropInDir(path)
+	if dir == "" {
+		return false
 	}
 
-	return false
+	_, err = os.Stat(dir)
+	return err == nil
 }
 
 func expandPath(path string) string {
-	if !strings.HasPrefix(path, "$BIN/") {
+	switch {
+	case strings.HasPrefix(path, "$BIN/"):
+		return filepath.Join(filepath.Dir(exepath.Abs), path[5:])
+
+	case strings.HasPrefix(path, "~/"):
+		home := os.Getenv("HOME")
+		if home == "" {
+			return path
+		}
+
+		return filepath.Join(home, path[2:])
+
+	default:
 		return path
 	}
+}
 
-	return filepath.Join(filepath.Dir(exepath.Abs), path[5:])
+// A value from a configuration file destined for a configurable.
+type assignment struct {
+	// Dotted path of the key.
+	key   string
+	value interface{}
+	file  string
+	line  int
 }
 
-func apply(c configurable.Configurable, v interface{}) error {
-	cch, ok := c.(interface {
-		CfChildren() []configurable.Configurable
+// Matches the values in the documents against the registered configurables.
+// Where more than one document sets a value, the last one wins. Returns the
+// configurables with values in the order they were first encountered.
+func collect(docs []document) (map[configurable.Configurable]*assignment, []configurable.Configurable) {
+	var top []configurable.Configurable
+	configurable.Visit(func(c configurable.Configurable) error {
+		top = append(top, c)
+		return nil
 	})
-	if ok {
-		children := cch.CfChildren()
-		if len(children) > 0 {
-			return applyChildren(children, v)
+
+	as := map[configurable.Configurable]*assignment{}
+	var order []configurable.Configurable
+	for i := range docs {
+		collectChildren(nil, top, docs[i].m, &docs[i], as, &order)
+	}
+
+	return as, order
+}
+
+func collectChildren(path []string, chs []configurable.Configurable, vm map[string]interface{}, doc *document, as map[configurable.Configurable]*assignment, order *[]configurable.Configurable) {
+	for _, ch := range chs {
+		name, ok := name(ch)
+		if !ok {
+			continue
+		}
+
+		vch, ok := vm[name]
+		if !ok {
+			continue
+		}
+
+		p := append(path[0:len(path):len(path)], name)
+		if gchs := children(ch); len(gchs) > 0 {
+			if vchm, ok := vch.(map[string]interface{}); ok {
+				collectChildren(p, gchs, vchm, doc, as, order)
+			}
+			continue
+		}
+
+		if _, ok := ch.(interface {
+			CfSetValue(x interface{}) error
+		}); !ok {
+			continue
+		}
+
+		key := strings.Join(p, ".")
+		ch = forward(ch, key, doc.path)
+
+		if _, ok := as[ch]; !ok {
+			*order = append(*order, ch)
+		}
+
+		as[ch] = &assignment{
+			key:   key,
+			value: vch,
+			file:  doc.path,
+			line:  doc.lines[key],
 		}
 	}
+}
 
+// Sets the value of a configurable, subject to priority. Returns true if the
+// value was set.
+func setValue(c configurable.Configurable, v interface{}) (bool, error) {
 	csv, ok := c.(interface {
 		CfSetValue(x interface{}) error
 	})
 	if !ok {
-		return nil
+		return false, nil
 	}
 
 	cprio, ok := c.(interface {
@@ -137,43 +639,120 @@ func apply(c configurable.Configurable, v interface{}) error {
 		if prio <= configurable.ConfigPriority {
 			err := csv.CfSetValue(v)
 			if err != nil {
-				return nil
+				return false, err
 			}
 
 			cprio.CfSetPriority(configurable.ConfigPriority)
+			return true, nil
 		}
 
-		return nil
+		return false, nil
 	} else {
-		return csv.CfSetValue(v)
+		err := csv.CfSetValue(v)
+		return err == nil, err
 	}
 }
 
-func applyChildren(chs []configurable.Configurable, v interface{}) error {
-	vm, ok := v.(map[string]interface{})
+func record(c configurable.Configurable, a *assignment, applied bool) {
+	provenance.Record(c, provenance.Source{
+		Kind:     provenance.Config,
+		Location: a.file,
+		Line:     a.line,
+		Value:    a.value,
+		Applied:  applied,
+	})
+}
+
+// Returns the configurable to which a value for c should be applied. This is
+// c itself, unless c is deprecated in favour of another configurable, in
+// which case it is the replacement. Warns if c is deprecated.
+func forward(c configurable.Configurable, key, path string) configurable.Configurable {
+	replacement, ok := deprecated(c)
 	if !ok {
-		return nil
+		return c
 	}
 
-	for _, ch := range chs {
-		applyChild(ch, vm)
+	if replacement == "" {
+		provenance.Warnf("%s: %s is deprecated", path, key)
+		return c
+	}
+
+	provenance.Warnf("%s: %s is deprecated; use %s instead", path, key, replacement)
+	rc := manual.ByName(replacement)
+	if _, ok := rc.(interface {
+		CfSetValue(x interface{}) error
+	}); !ok {
+		return c
 	}
 
-	return nil
+	return rc
 }
 
-func applyChild(ch configurable.Configurable, vm map[string]interface{}) error {
-	name, ok := name(ch)
+func deprecated(c configurable.Configurable) (replacement string, ok bool) {
+	v, ok := c.(interface {
+		CfDeprecated() (string, bool)
+	})
 	if !ok {
-		return nil
+		return "", false
+	}
+
+	return v.CfDeprecated()
+}
+
+func restartRequired(c configurable.Configurable) bool {
+	cr, ok := c.(interface {
+		CfRestartRequired() bool
+	})
+
+	return ok && cr.CfRestartRequired()
+}
+
+// Reports whether a value from a configuration file would take effect, or
+// whether a higher priority source has set the configurable.
+func configWins(c configurable.Configurable) bool {
+	cprio, ok := c.(interface {
+		CfGetPriority() configurable.Priority
+	})
+
+	return !ok || cprio.CfGetPriority() <= configurable.ConfigPriority
+}
+
+// Reverts a configurable whose value was set from a configuration file to its
+// default value. Returns true if the value was reset.
+func resetValue(c configurable.Configurable) bool {
+	cprio, ok := c.(interface {
+		CfSetPriority(priority configurable.Priority)
+		CfGetPriority() configurable.Priority
+	})
+	if !ok || cprio.CfGetPriority() != configurable.ConfigPriority {
+		return false
+	}
+
+	dflt, ok := defaultValue(c)
+	if !ok || dflt == nil {
+		return false
 	}
 
-	vch, ok := vm[name]
+	csv, ok := c.(interface {
+		CfSetValue(x interface{}) error
+	})
+	if !ok || csv.CfSetValue(dflt) != nil {
+		return false
+	}
+
+	cprio.CfSetPriority(0)
+	return true
+}
+
+func defaultValue(c configurable.Configurable) (dflt interface{}, ok bool) {
+	v, ok := c.(interface {
+		CfDefaultValue() interface{}
+	})
 	if !ok {
-		return nil
+		return nil, false
 	}
 
-	return apply(ch, vch)
+	return v.CfDefaultValue(), true
 }
 
 func name(c configurable.Configurable) (name string, ok bool) {
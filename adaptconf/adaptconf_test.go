@@ -0,0 +1,757 @@
+package adaptconf
+
+import "os"
+import "fmt"
+import "log"
+import "testing"
+import "strings"
+import "regexp"
+import "path/filepath"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/cflag"
+import "gopkg.in/hlandau/easyconfig.v1/cstruct"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+func TestFindUnknownKeys(t *testing.T) {
+	g := cflag.NewGroup(&cflag.NoReg, "example")
+	cflag.String(g, "bind", ":80", "Address to bind to")
+	cflag.Int(g, "workers", 4, "Number of workers")
+
+	m := map[string]interface{}{
+		"example": map[string]interface{}{
+			"bnd":     ":8080",
+			"workers": 8,
+		},
+		"exmaple": map[string]interface{}{},
+		"zzzzzz":  1,
+	}
+
+	keys := findUnknownKeys([]configurable.Configurable{g}, m)
+	expected := []UnknownKey{
+		{Key: "example.bnd", Suggestion: "example.bind"},
+		{Key: "exmaple", Suggestion: "example"},
+		{Key: "zzzzzz"},
+	}
+
+	if len(keys) != len(expected) {
+		t.Fatalf("unexpected unknown keys: %v", keys)
+	}
+
+	for i := range keys {
+		if keys[i] != expected[i] {
+			t.Errorf("unknown key %d: got %v, expected %v", i, keys[i], expected[i])
+		}
+	}
+}
+
+func TestSearchPathsXDG(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_CONFIG_DIRS", "/a:relative:/b")
+
+	paths := SearchPaths("foo")
+	expected := []string{
+		"/etc/foo/foo.conf",
+		"/etc/foo.conf",
+		"/b/foo/foo.conf",
+		"/a/foo/foo.conf",
+		"/home/u/.config/foo/foo.conf",
+		"etc/foo.conf",
+		"$BIN/foo.conf",
+		"$BIN/../etc/foo/foo.conf",
+		"$BIN/../etc/foo.conf",
+	}
+
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected search paths: %v", paths)
+	}
+
+	for i := range paths {
+		if paths[i] != expected[i] {
+			t.Errorf("search path %d: got %#v, expected %#v", i, paths[i], expected[i])
+		}
+	}
+}
+
+func TestDropInPaths(t *testing.T) {
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "foo.conf")
+	dropInDir := confPath + ".d"
+	err := os.Mkdir(dropInDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"20-b.conf", "10-a.json", "30-c.toml", "README", "20-b.conf~", ".20-b.conf.swp", "40-d.conf.dpkg-old"} {
+		err := os.WriteFile(filepath.Join(dropInDir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := dropInPaths(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"10-a.json", "20-b.conf", "30-c.toml"}
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected drop-in paths: %v", paths)
+	}
+
+	for i := range paths {
+		if paths[i] != filepath.Join(dropInDir, expected[i]) {
+			t.Errorf("drop-in %d: got %#v, expected %#v", i, paths[i], expected[i])
+		}
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.conf":       `include = ["common.conf", "secrets/*.conf"]`,
+		"common.conf":     `x = 1`,
+		"secrets/b.conf":  `y = 2`,
+		"secrets/a.conf":  `z = 3`,
+		"cycle.conf":      `include = "cycle2.conf"`,
+		"cycle2.conf":     `include = "cycle.conf"`,
+		"missing.conf":    `include = "nonexistent.conf"`,
+		"secrets/c.conf~": ``,
+		"secrets/README":  ``,
+	}
+	err := os.Mkdir(filepath.Join(dir, "secrets"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = LoadPath(filepath.Join(dir, "main.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"common.conf", "secrets/a.conf", "secrets/b.conf", "main.conf"}
+	paths := ConfPaths()
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+	for i := range paths {
+		if paths[i] != filepath.Join(dir, expected[i]) {
+			t.Errorf("path %d: got %#v, expected %#v", i, paths[i], expected[i])
+		}
+	}
+
+	err = LoadPath(filepath.Join(dir, "cycle.conf"))
+	ie, ok := err.(*IncludeError)
+	if !ok {
+		t.Fatalf("expected include error, got %v", err)
+	}
+	if len(ie.Chain) != 3 || ie.Chain[2] != filepath.Join(dir, "cycle.conf") {
+		t.Errorf("unexpected include chain: %v", ie.Chain)
+	}
+
+	err = LoadPath(filepath.Join(dir, "missing.conf"))
+	if _, ok := err.(*IncludeError); !ok {
+		t.Fatalf("expected include error, got %v", err)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	defer func() { Interpolation = NoInterpolation }()
+	t.Setenv("EC_TEST_HOST", "example.com")
+	t.Setenv("EC_TEST_EMPTY", "")
+
+	m := map[string]interface{}{
+		"server": map[string]interface{}{
+			"host": "${EC_TEST_HOST}",
+			"port": int64(8080),
+			"bind": "${server.host}:${server.port}",
+		},
+		"url":     "http://${server.bind}/$${literal}",
+		"dflt":    "${EC_TEST_EMPTY:-${env:EC_TEST_HOST}}",
+		"list":    []interface{}{"${env:EC_TEST_HOST}", int64(1)},
+		"missing": "${EC_TEST_UNSET}x${no.such.key}",
+	}
+
+	Interpolation = Interpolate
+	r, err := interpolate("test.conf", m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := r["url"]; s != "http://example.com:8080/${literal}" {
+		t.Errorf("unexpected url: %#v", s)
+	}
+	if s := r["dflt"]; s != "example.com" {
+		t.Errorf("unexpected default: %#v", s)
+	}
+	if s := r["list"].([]interface{})[0]; s != "example.com" {
+		t.Errorf("unexpected list element: %#v", s)
+	}
+	if s := r["missing"]; s != "x" {
+		t.Errorf("unexpected unresolved expansion: %#v", s)
+	}
+	if s := m["url"]; s != "http://${server.bind}/$${literal}" {
+		t.Errorf("original document modified: %#v", s)
+	}
+
+	Interpolation = StrictInterpolate
+	_, err = interpolate("test.conf", m, nil)
+	ue, ok := err.(*UnresolvedError)
+	if !ok || len(ue.Names) != 2 || ue.Names[0] != "EC_TEST_UNSET" || ue.Names[1] != "no.such.key" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = interpolate("test.conf", map[string]interface{}{
+		"a": map[string]interface{}{"x": "${a.y}", "y": "${a.x}"},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "reference cycle") {
+		t.Errorf("expected reference cycle error, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	g := cflag.NewGroup(nil, "reloadtest")
+	level := cflag.String(g, "level", "info", "Log level")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+	changes := 0
+	level.RegisterOnChange(func(*cflag.StringFlag) { changes++ })
+
+	path := filepath.Join(t.TempDir(), "reload.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[reloadtest]\nlevel = \"debug\"\nbind = \":8080\"\n")
+	err := LoadPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level.Value() != "debug" || bind.Value() != ":8080" || changes != 1 {
+		t.Fatalf("unexpected values after load: %#v %#v %d", level.Value(), bind.Value(), changes)
+	}
+
+	write("[reloadtest]\nlevel = \"debug\"\nbind = [\n")
+	_, err = Reload()
+	if err == nil {
+		t.Fatal("expected error reloading invalid file")
+	}
+
+	write("[reloadtest]\nlevel = \"warn\"\n")
+	res, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level.Value() != "warn" || bind.Value() != ":80" || changes != 2 {
+		t.Errorf("unexpected values after reload: %#v %#v %d", level.Value(), bind.Value(), changes)
+	}
+	if len(res.Changed) != 2 || res.Changed[0] != "reloadtest.level" || res.Changed[1] != "reloadtest.bind" {
+		t.Errorf("unexpected changed keys: %v", res.Changed)
+	}
+
+	res, err = Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changed) != 0 || changes != 2 {
+		t.Errorf("unexpected changes reloading unchanged file: %v", res.Changed)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	g := cflag.NewGroup(nil, "rollbacktest")
+	level := cflag.String(g, "level", "info", "Log level")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+
+	path := filepath.Join(t.TempDir(), "rollback.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[rollbacktest]\nlevel = \"debug\"\n")
+	err := LoadPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolling back without a reload does nothing.
+	Rollback()
+	if level.Value() != "debug" {
+		t.Fatalf("unexpected value after rollback without reload: %#v", level.Value())
+	}
+
+	write("[rollbacktest]\nlevel = \"warn\"\nbind = \":8080\"\n")
+	_, err = Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Rollback()
+	if level.Value() != "debug" || bind.Value() != ":80" {
+		t.Errorf("unexpected values after rollback: %#v %#v", level.Value(), bind.Value())
+	}
+	if prio := bind.CfGetPriority(); prio != 0 {
+		t.Errorf("unexpected priority after rollback: %v", prio)
+	}
+	if src := provenance.Get(bind); src.Kind != provenance.Default {
+		t.Errorf("unexpected provenance after rollback: %#v", src)
+	}
+	if src := provenance.Get(level); src.Value != "debug" {
+		t.Errorf("unexpected provenance after rollback: %#v", src)
+	}
+
+	// The values rolled back are applied again by the next reload.
+	res, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level.Value() != "warn" || bind.Value() != ":8080" || len(res.Changed) != 2 {
+		t.Errorf("unexpected values after reload: %#v %#v %v", level.Value(), bind.Value(), res.Changed)
+	}
+}
+
+func TestReloadRestartRequired(t *testing.T) {
+	g := cflag.NewGroup(nil, "restarttest")
+	level := cflag.String(g, "level", "info", "Log level")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to", cflag.Restart())
+
+	path := filepath.Join(t.TempDir(), "restart.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[restarttest]\nlevel = \"debug\"\nbind = \":8080\"\n")
+	err := LoadPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("[restarttest]\nlevel = \"warn\"\nbind = \":9090\"\n")
+	for i := 0; i < 2; i++ {
+		res, err := Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level.Value() != "warn" || bind.Value() != ":8080" {
+			t.Errorf("unexpected values after reload: %#v %#v", level.Value(), bind.Value())
+		}
+		if len(res.RestartRequired) != 1 || res.RestartRequired[0] != "restarttest.bind" {
+			t.Errorf("unexpected restart required keys: %v", res.RestartRequired)
+		}
+	}
+
+	write("[restarttest]\nlevel = \"warn\"\nbind = \":8080\"\n")
+	res, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.RestartRequired) != 0 || len(res.Changed) != 0 {
+		t.Errorf("unexpected result after reverting: %+v", res)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	g := cflag.NewGroup(nil, "provtest")
+	level := cflag.String(g, "level", "info", "Log level")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+
+	path := filepath.Join(t.TempDir(), "prov.conf")
+	err := os.WriteFile(path, []byte("# comment\n[provtest]\n\nlevel = \"debug\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = LoadPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := provenance.Get(level)
+	if src.Kind != provenance.Config || src.Location != path || src.Line != 4 || !src.Applied {
+		t.Errorf("unexpected provenance for level: %#v", src)
+	}
+
+	if s := src.String(); s != "config file "+path+":4" {
+		t.Errorf("unexpected description: %q", s)
+	}
+
+	if src := provenance.Get(bind); src.Kind != provenance.Default {
+		t.Errorf("unexpected provenance for bind: %#v", src)
+	}
+}
+
+func TestFormatsInt(t *testing.T) {
+	docs := map[string]string{
+		".toml": "[inttest_toml]\nworkers = 8\nbig = 5\ncount = 7\nratio = 2\nscale = 1.5\n",
+		".json": `{"inttest_json": {"workers": 8, "big": 5, "count": 7, "ratio": 2, "scale": 1.5}}`,
+		".yaml": "inttest_yaml:\n  workers: 8\n  big: 5\n  count: 7\n  ratio: 2\n  scale: 1.5\n",
+	}
+
+	for ext, doc := range docs {
+		var tgt struct {
+			Workers int     `usage:"Number of workers" default:"4"`
+			Big     int64   `usage:"A large number"`
+			Count   uint    `usage:"A count"`
+			Ratio   float64 `usage:"A ratio"`
+			Scale   float64 `usage:"A scale"`
+		}
+		configurable.Register(cstruct.MustNew(&tgt, "inttest_"+ext[1:]))
+
+		path := filepath.Join(t.TempDir(), "int"+ext)
+		err := os.WriteFile(path, []byte(doc), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = LoadPath(path)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if tgt.Workers != 8 || tgt.Big != 5 || tgt.Count != 7 || tgt.Ratio != 2 || tgt.Scale != 1.5 {
+			t.Errorf("%s: unexpected values: %#v", ext, tgt)
+		}
+	}
+
+	// Values which cannot be represented exactly are rejected.
+	g := cflag.NewGroup(nil, "inttest_lossy")
+	workers := cflag.Int(g, "workers", 4, "Number of workers")
+	var lossy struct {
+		Count uint `usage:"A count"`
+	}
+	configurable.Register(cstruct.MustNew(&lossy, "inttest_lossy2"))
+
+	path := filepath.Join(t.TempDir(), "lossy.toml")
+	err := os.WriteFile(path, []byte("[inttest_lossy]\nworkers = 1.5\n[inttest_lossy2]\ncount = -1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	StrictValues = true
+	defer func() { StrictValues = false }()
+
+	err = LoadPath(path)
+	if err == nil || workers.Value() != 4 || lossy.Count != 0 {
+		t.Errorf("expected error for inexact values, got %v: %#v, %#v", err, workers.Value(), lossy.Count)
+	}
+}
+
+func TestLoadPathInvalidValue(t *testing.T) {
+	g := cflag.NewGroup(nil, "invalidtest")
+	workers := cflag.Int(g, "workers", 4, "Number of workers")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+
+	path := filepath.Join(t.TempDir(), "invalid.conf")
+	err := os.WriteFile(path, []byte("[invalidtest]\nworkers = \"many\"\nbind = \":8080\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default, invalid values are only warned about.
+	var warnings []string
+	provenance.Warnf = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	defer func() { provenance.Warnf = log.Printf }()
+
+	err = LoadPath(path)
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "invalidtest.workers") {
+		t.Errorf("expected warning setting invalidtest.workers, got %v, %v", err, warnings)
+	}
+	if workers.Value() != 4 || bind.Value() != ":8080" {
+		t.Errorf("unexpected values: %#v, %#v", workers.Value(), bind.Value())
+	}
+
+	StrictValues = true
+	defer func() { StrictValues = false }()
+	bind.SetValue(":80")
+
+	err = LoadPath(path)
+	if err == nil || !strings.Contains(err.Error(), "invalidtest.workers") {
+		t.Errorf("expected error setting invalidtest.workers, got %v", err)
+	}
+	if workers.Value() != 4 || bind.Value() != ":8080" {
+		t.Errorf("unexpected values: %#v, %#v", workers.Value(), bind.Value())
+	}
+}
+
+func TestDump(t *testing.T) {
+	g := cflag.NewGroup(nil, "dumptest")
+	cflag.String(g, "bind", ":80", "Address to bind to")
+	workers := cflag.Int(g, "workers", 4, "Number of workers")
+	workers.SetValue(8)
+	password := cflag.Secret(g, "password", "", "Password")
+	password.SetValue("hunter2")
+
+	for _, ext := range []string{".toml", ".yaml", ".json"} {
+		var b strings.Builder
+		err := Dump(&b, ext, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "dump"+ext)
+		err = os.WriteFile(path, []byte(b.String()), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, _, err := decodeFile(path)
+		if err != nil {
+			t.Fatalf("%s: cannot decode dump: %v\n%s", ext, err, b.String())
+		}
+
+		// Secret values are omitted.
+		dm, _ := m["dumptest"].(map[string]interface{})
+		if _, ok := dm["password"]; len(dm) != 1 || ok {
+			t.Errorf("%s: unexpected dump:\n%s", ext, b.String())
+		}
+
+		// The dumped values can be loaded back.
+		workers.SetValue(4)
+		err = LoadPath(path)
+		if err != nil || workers.Value() != 8 || password.Value() != "hunter2" {
+			t.Errorf("%s: cannot load dump: %v: workers = %#v, password = %#v", ext, err, workers.Value(), password.Value())
+		}
+	}
+
+	// Values shows that secret values are set, but not what they are.
+	if vm, _ := Values(true)["dumptest"].(map[string]interface{}); vm["password"] != provenance.Redacted {
+		t.Errorf("unexpected values: %#v", vm)
+	}
+
+	var b strings.Builder
+	err := Dump(&b, "yaml", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "bind: :80") {
+		t.Errorf("expected default values in dump:\n%s", b.String())
+	}
+
+	err = Dump(&b, ".ini", false)
+	if err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestYAMLKeyLines(t *testing.T) {
+	lines := yamlFormat{}.KeyLines([]byte("# comment\nexample:\n  bind: \":80\"\n\n  workers: 4\n"))
+	if lines["example"] != 2 || lines["example.bind"] != 3 || lines["example.workers"] != 5 {
+		t.Errorf("unexpected key lines: %v", lines)
+	}
+}
+
+func TestSample(t *testing.T) {
+	g := cflag.NewGroup(nil, "Sample Options")
+	cflag.String(g, "bind", ":80", "Address to bind to")
+	sub := cflag.NewGroup(g, "limits")
+	cflag.Int(sub, "workers", 4, "Number of workers")
+
+	// Values in effect, for example from the environment, are not shown.
+	var tgt struct {
+		Token string `usage:"API token"`
+	}
+	configurable.Register(cstruct.MustNew(&tgt, "sampletoken"))
+	tgt.Token = "from-env"
+
+	for _, ext := range []string{".toml", ".yaml"} {
+		var b strings.Builder
+		err := Sample(&b, ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := b.String()
+		if !strings.Contains(s, "# Address to bind to\n") || !strings.Contains(s, "# Number of workers\n") {
+			t.Errorf("%s: expected usage comments in sample:\n%s", ext, s)
+		}
+		if !strings.Contains(s, "# API token\n") || strings.Contains(s, "from-env") {
+			t.Errorf("%s: expected default value in sample:\n%s", ext, s)
+		}
+
+		// Uncomment everything and check that the result loads.
+		s = regexp.MustCompile(`(?m)^#(\S)`).ReplaceAllString(s, "$1")
+		s = regexp.MustCompile(`(?m)^#( +\S+:)`).ReplaceAllString(s, "$1")
+		m, err := formatForPath("x" + ext).Decode([]byte(s))
+		if err != nil {
+			t.Fatalf("%s: cannot decode uncommented sample: %v\n%s", ext, err, s)
+		}
+
+		gm, _ := m["Sample Options"].(map[string]interface{})
+		keys := findUnknownKeys([]configurable.Configurable{g}, map[string]interface{}{"Sample Options": gm})
+		lm, _ := gm["limits"].(map[string]interface{})
+		if len(keys) != 0 || gm["bind"] != ":80" || fmt.Sprint(lm["workers"]) != "4" {
+			t.Errorf("%s: unexpected uncommented sample: %v\n%s", ext, m, s)
+		}
+	}
+
+	err := Sample(&strings.Builder{}, ".json")
+	if err == nil {
+		t.Error("expected error for format without comments")
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	g := cflag.NewGroup(nil, "deptest")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+	cflag.String(g, "listen", "", "Address to listen on", cflag.Deprecated("deptest.bind"))
+
+	var warnings []string
+	provenance.Warnf = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	defer func() { provenance.Warnf = log.Printf }()
+
+	path := filepath.Join(t.TempDir(), "dep.conf")
+	err := os.WriteFile(path, []byte("[deptest]\nlisten = \":8080\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = LoadPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bind.Value() != ":8080" {
+		t.Errorf("value not forwarded to replacement: %#v", bind.Value())
+	}
+	if len(warnings) != 1 || warnings[0] != path+": deptest.listen is deprecated; use deptest.bind instead" {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+func TestLoadPaths(t *testing.T) {
+	defer func() { Layered = false }()
+
+	dir := t.TempDir()
+	for _, layered := range []bool{false, true} {
+		Layered = layered
+		gn := fmt.Sprintf("pathstest_%v", layered)
+		g := cflag.NewGroup(nil, gn)
+		a := cflag.String(g, "a", "default", "")
+		b := cflag.String(g, "b", "default", "")
+		c := cflag.String(g, "c", "default", "")
+
+		// In order of increasing precedence.
+		files := []string{
+			"a = \"1\"\nb = \"1\"\nc = \"1\"\n",
+			"",
+			"b = \"3\"\nc = \"3\"\n",
+			"c = \"4\"\n",
+		}
+		var paths []string
+		for i, contents := range files {
+			path := filepath.Join(dir, fmt.Sprintf("%s_%d.conf", gn, i))
+			paths = append(paths, path)
+			if contents == "" {
+				continue
+			}
+
+			err := os.WriteFile(path, []byte("["+gn+"]\n"+contents), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := LoadPaths(paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"default", "default", "4"}
+		expectedPaths := paths[3:]
+		if layered {
+			expected = []string{"1", "3", "4"}
+			expectedPaths = []string{paths[0], paths[2], paths[3]}
+		}
+
+		got := []string{a.Value(), b.Value(), c.Value()}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("layered=%v: value %d: got %#v, expected %#v", layered, i, got[i], expected[i])
+			}
+		}
+
+		if src := provenance.Get(b); layered && src.Location != paths[2] {
+			t.Errorf("layered=%v: unexpected provenance for b: %#v", layered, src)
+		}
+
+		confPaths := ConfPaths()
+		if fmt.Sprint(confPaths) != fmt.Sprint(expectedPaths) {
+			t.Errorf("layered=%v: got paths %v, expected %v", layered, confPaths, expectedPaths)
+		}
+		if LastConfPath() != paths[3] {
+			t.Errorf("layered=%v: unexpected last path %#v", layered, LastConfPath())
+		}
+	}
+}
+
+func TestLoadSearchPaths(t *testing.T) {
+	defer func() { Layered = false }()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "home"))
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "sys1")+":"+filepath.Join(dir, "sys2"))
+
+	for _, layered := range []bool{false, true} {
+		Layered = layered
+		prog := fmt.Sprintf("searchtest_%v", layered)
+		g := cflag.NewGroup(nil, prog)
+		x := cflag.String(g, "x", "default", "")
+		y := cflag.String(g, "y", "default", "")
+		z := cflag.String(g, "z", "default", "")
+
+		// sys1 takes precedence over sys2, and the user's directory over both.
+		files := map[string]string{
+			"sys2": "x = \"sys2\"\ny = \"sys2\"\nz = \"sys2\"\n",
+			"sys1": "y = \"sys1\"\nz = \"sys1\"\n",
+			"home": "z = \"home\"\n",
+		}
+		for d, contents := range files {
+			path := filepath.Join(dir, d, prog, prog+".conf")
+			err := os.MkdirAll(filepath.Dir(path), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = os.WriteFile(path, []byte("["+prog+"]\n"+contents), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := Load(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"default", "default", "home"}
+		if layered {
+			expected = []string{"sys2", "sys1", "home"}
+		}
+
+		got := []string{x.Value(), y.Value(), z.Value()}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("layered=%v: got %v, expected %v", layered, got, expected)
+		}
+
+		if n := len(ConfPaths()); (layered && n != 3) || (!layered && n != 1) {
+			t.Errorf("layered=%v: unexpected paths: %v", layered, ConfPaths())
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package adaptconf
+
+import "os"
+import "sort"
+import "path/filepath"
+
+// Returns the directory searched for drop-in configuration fragments which
+// accompany the configuration file at confPath. The default appends ".d" to
+// the path, so that fragments for /etc/foo.conf are found in /etc/foo.conf.d.
+//
+// Replace this function to relocate the drop-in directory. If it returns "",
+// no drop-in fragments are loaded.
+var DropInDir = func(confPath string) string {
+	return confPath + ".d"
+}
+
+// Patterns, as understood by filepath.Match, matched against the names of
+// files in a drop-in directory. Matching files are skipped. The defaults
+// exclude hidden files, editor backup and swap files and files left behind by
+// package managers.
+var DropInIgnore = []string{
+	".*",
+	"*~",
+	"#*#",
+	"*.swp",
+	"*.bak",
+	"*.dpkg-*",
+	"*.rpmnew",
+	"*.rpmsave",
+	"*.ucf-*",
+}
+
+// Returns the drop-in fragments accompanying the configuration file at
+// confPath, in lexical order of file name. Only files with an extension for
+// which a format is registered are returned. If the drop-in directory is
+// disabled, returns nil and no error.
+func dropInPaths(confPath string) ([]string, error) {
+	dir := DropInDir(confPath)
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() || ignoreDropIn(e.Name()) || !hasFormat(e.Name()) {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func ignoreDropIn(name string) bool {
+	for _, pattern := range DropInIgnore {
+		if m, _ := filepath.Match(pattern, name); m {
+			return true
+		}
+	}
+
+	return false
+}
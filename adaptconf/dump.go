@@ -0,0 +1,100 @@
+package adaptconf
+
+import "fmt"
+import "io"
+import "reflect"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+// Returns the effective values of all registered configurables, nested by
+// group in the same way as configuration files. If nonDefault is true, values
+// equal to the configurable's default value are omitted, as are groups left
+// empty as a result. Deprecated configurables are omitted. Non-empty values
+// of secret configurables are replaced with provenance.Redacted.
+func Values(nonDefault bool) map[string]interface{} {
+	return collectValues(nonDefault, false)
+}
+
+// Like Values, but if omitSecrets is true, secret configurables are omitted
+// rather than redacted.
+func collectValues(nonDefault, omitSecrets bool) map[string]interface{} {
+	m := map[string]interface{}{}
+	configurable.Visit(func(c configurable.Configurable) error {
+		if c != confFlag {
+			values(c, m, nonDefault, omitSecrets)
+		}
+		return nil
+	})
+
+	return m
+}
+
+func values(c configurable.Configurable, m map[string]interface{}, nonDefault, omitSecrets bool) {
+	n, ok := name(c)
+	if !ok {
+		return
+	}
+
+	cc, ok := c.(interface {
+		CfChildren() []configurable.Configurable
+	})
+	if ok {
+		sub := map[string]interface{}{}
+		for _, ch := range cc.CfChildren() {
+			values(ch, sub, nonDefault, omitSecrets)
+		}
+		if len(sub) > 0 {
+			m[n] = sub
+		}
+		return
+	}
+
+	if _, ok := deprecated(c); ok {
+		return
+	}
+
+	if omitSecrets && provenance.IsSecret(c) {
+		return
+	}
+
+	v, ok := value(c)
+	if !ok || v == nil {
+		return
+	}
+
+	if dflt, ok := defaultValue(c); ok && nonDefault && reflect.DeepEqual(v, dflt) {
+		return
+	}
+
+	if provenance.IsSecret(c) && v != "" {
+		v = provenance.Redacted
+	}
+
+	m[n] = v
+}
+
+// Writes the effective values of all registered configurables to w, in the
+// format registered for the given extension, e.g. ".toml", ".yaml" or
+// ".json". The output can be loaded as a configuration file. Secret
+// configurables are omitted, so that loading the output leaves them
+// unchanged. If nonDefault is true, only values which differ from their
+// defaults are written. See Values.
+func Dump(w io.Writer, ext string, nonDefault bool) error {
+	f, ok := formatForExt(ext)
+	if !ok {
+		return fmt.Errorf("unknown configuration file format: %q", ext)
+	}
+
+	enc, ok := f.(Encoder)
+	if !ok {
+		return fmt.Errorf("configuration file format does not support encoding: %q", ext)
+	}
+
+	b, err := enc.Encode(collectValues(nonDefault, true))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
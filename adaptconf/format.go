@@ -0,0 +1,351 @@
+package adaptconf
+
+import "fmt"
+import "sort"
+import "bytes"
+import "strings"
+import "path/filepath"
+import "encoding/json"
+import "reflect"
+import "github.com/BurntSushi/toml"
+import "gopkg.in/yaml.v3"
+
+// A configuration file format.
+type Format interface {
+	// Decodes a configuration file. Tables or objects are represented as
+	// map[string]interface{}. Integers of any integer type, and json.Number
+	// values, are converted to int where they fit, so that the same file
+	// sets configurables in the same way whatever its format.
+	Decode(data []byte) (map[string]interface{}, error)
+}
+
+// Optionally implemented by a Format to report where keys are defined, so
+// that the provenance of values can include line numbers.
+type LineFinder interface {
+	// Returns the line numbers, starting from 1, at which keys are defined,
+	// indexed by dotted path. The result may be incomplete.
+	KeyLines(data []byte) map[string]int
+}
+
+// Optionally implemented by a Format which can write configuration files, for
+// use by Dump.
+type Encoder interface {
+	// Encodes a configuration file. Tables or objects are represented as
+	// map[string]interface{}.
+	Encode(m map[string]interface{}) ([]byte, error)
+}
+
+var formats = map[string]Format{}
+
+// Registers a format for files with the given extension, which should include
+// the leading dot. Registering an extension a second time replaces the format
+// previously registered for it.
+func RegisterFormat(ext string, f Format) {
+	formats[strings.ToLower(ext)] = f
+}
+
+// Returns the extensions for which a format is registered, in lexical order.
+func Extensions() []string {
+	exts := make([]string, 0, len(formats))
+	for ext := range formats {
+		exts = append(exts, ext)
+	}
+
+	sort.Strings(exts)
+	return exts
+}
+
+// Returns the format registered for the path's extension. Files with an
+// unregistered extension are assumed to be TOML.
+func formatForPath(path string) Format {
+	f, ok := formats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return tomlFormat{}
+	}
+
+	return f
+}
+
+// Returns the format registered for the extension, which may be specified
+// with or without the leading dot.
+func formatForExt(ext string) (Format, bool) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	f, ok := formats[strings.ToLower(ext)]
+	return f, ok
+}
+
+func hasFormat(path string) bool {
+	_, ok := formats[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+type tomlFormat struct{}
+
+func (tomlFormat) Decode(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	_, err := toml.Decode(string(data), &m)
+	return m, err
+}
+
+func (tomlFormat) Encode(m map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := toml.NewEncoder(&b).Encode(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// Scans the document line by line, keeping track of the current table. This
+// is not a full TOML parser, so may occasionally give a wrong answer, for
+// example for keys inside multi-line strings.
+func (tomlFormat) KeyLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	var table []string
+	inString := false
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		wasInString := inString
+		if strings.Count(line, "\"\"\"")%2 == 1 || strings.Count(line, "'''")%2 == 1 {
+			inString = !inString
+		}
+		if wasInString || line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+
+			table = splitTOMLKey(strings.TrimLeft(line[0:end], "["))
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+
+		key := strings.Join(append(table[0:len(table):len(table)], splitTOMLKey(line[0:eq])...), ".")
+		if _, ok := lines[key]; !ok {
+			lines[key] = i + 1
+		}
+	}
+
+	return lines
+}
+
+// Splits a possibly dotted and quoted TOML key into its components.
+func splitTOMLKey(key string) []string {
+	var parts []string
+	var cur strings.Builder
+	var quote byte
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			parts = append(parts, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+
+	return append(parts, strings.TrimSpace(cur.String()))
+}
+
+type jsonFormat struct{}
+
+// Numbers are decoded as json.Number so that integers can be distinguished
+// from other numbers; see normalizeNumbers.
+func (jsonFormat) Decode(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("top level must be an object")
+	}
+
+	return m, nil
+}
+
+func (jsonFormat) Encode(m map[string]interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+func (jsonFormat) KeyLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t {
+		case json.Delim('{'):
+			for dec.More() {
+				t, err := dec.Token()
+				if err != nil {
+					return err
+				}
+
+				k, _ := t.(string)
+				p := append(path[0:len(path):len(path)], k)
+				lines[strings.Join(p, ".")] = 1 + bytes.Count(data[0:dec.InputOffset()], []byte("\n"))
+				err = walk(p)
+				if err != nil {
+					return err
+				}
+			}
+
+		case json.Delim('['):
+			for dec.More() {
+				err := walk(path)
+				if err != nil {
+					return err
+				}
+			}
+
+		default:
+			return nil
+		}
+
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		return err
+	}
+
+	walk(nil)
+	return lines
+}
+
+// Converts integers of any integer type to int where they fit, and
+// json.Number values to int or float64, throughout a decoded document.
+func normalizeNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = normalizeNumbers(e)
+		}
+		return x
+	case []map[string]interface{}:
+		for _, e := range x {
+			normalizeNumbers(e)
+		}
+		return x
+	case []interface{}:
+		for i, e := range x {
+			x[i] = normalizeNumbers(e)
+		}
+		return x
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return normalizeNumbers(n)
+		}
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+		return x.String()
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		n := reflect.ValueOf(x)
+		switch n.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if int64(int(n.Int())) == n.Int() {
+				return int(n.Int())
+			}
+		default:
+			if n.Uint() <= uint64(^uint(0)>>1) {
+				return int(n.Uint())
+			}
+		}
+		return x
+	default:
+		return v
+	}
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) Decode(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	err := yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+
+	return m, nil
+}
+
+func (yamlFormat) Encode(m map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(m)
+}
+
+func (yamlFormat) KeyLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return lines
+	}
+
+	var walk func(path []string, n *yaml.Node)
+	walk = func(path []string, n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, ch := range n.Content {
+				walk(path, ch)
+			}
+
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				k := n.Content[i]
+				p := append(path[0:len(path):len(path)], k.Value)
+				key := strings.Join(p, ".")
+				if _, ok := lines[key]; !ok {
+					lines[key] = k.Line
+				}
+				walk(p, n.Content[i+1])
+			}
+		}
+	}
+
+	walk(nil, &doc)
+	return lines
+}
+
+func init() {
+	RegisterFormat(".conf", tomlFormat{})
+	RegisterFormat(".toml", tomlFormat{})
+	RegisterFormat(".json", jsonFormat{})
+	RegisterFormat(".yaml", yamlFormat{})
+	RegisterFormat(".yml", yamlFormat{})
+}
@@ -0,0 +1,167 @@
+package adaptconf
+
+import "fmt"
+import "sort"
+import "strings"
+import "path/filepath"
+
+// The top-level key which names further files to be loaded, for example:
+//
+//	include = ["common.conf", "secrets/*.conf"]
+//
+// The value may be a string or an array of strings. Relative paths are
+// resolved relative to the directory containing the including file, and
+// paths may contain glob patterns as understood by filepath.Glob. A pattern
+// which matches nothing is ignored, but a path without glob metacharacters
+// must exist.
+//
+// Included files are loaded before the values in the including file are
+// applied, so the including file overrides anything set by the files it
+// includes. Files matched by a single pattern are loaded in lexical order.
+//
+// Set to "" to disable include processing, in which case the key is treated
+// like any other.
+var IncludeKey = "include"
+
+// The maximum depth to which includes may be nested.
+var MaxIncludeDepth = 8
+
+// Returned when loading a file reached via an include directive fails.
+type IncludeError struct {
+	// The files leading to the failure, starting with the file originally
+	// loaded and ending with the file which could not be loaded.
+	Chain []string
+	Err   error
+}
+
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("%s (include chain: %s)", e.Err, strings.Join(e.Chain, " -> "))
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
+
+// Reads the files included by the file at path, whose decoded contents are m,
+// and appends them to docs. The include key is removed from m.
+func readIncludes(path, mainPath string, m map[string]interface{}, chain []string, docs []document) ([]document, error) {
+	if IncludeKey == "" {
+		return docs, nil
+	}
+
+	v, ok := m[IncludeKey]
+	if !ok {
+		return docs, nil
+	}
+
+	delete(m, IncludeKey)
+
+	chain = append(chain[0:len(chain):len(chain)], path)
+	fail := func(target string, err error) error {
+		return &IncludeError{
+			Chain: append(chain[0:len(chain):len(chain)], target),
+			Err:   err,
+		}
+	}
+
+	patterns, err := includePatterns(v)
+	if err != nil {
+		return nil, fail(path, err)
+	}
+
+	for _, pattern := range patterns {
+		targets, err := resolveInclude(path, pattern)
+		if err != nil {
+			return nil, fail(pattern, err)
+		}
+
+		for _, target := range targets {
+			if len(chain) > MaxIncludeDepth {
+				return nil, fail(target, fmt.Errorf("Includes nested more than %d deep", MaxIncludeDepth))
+			}
+
+			if inChain(target, chain) {
+				return nil, fail(target, fmt.Errorf("Include cycle"))
+			}
+
+			docs, err = readFile(target, mainPath, chain, docs)
+			if err != nil {
+				if _, ok := err.(*IncludeError); ok {
+					return nil, err
+				}
+
+				return nil, fail(target, err)
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+func includePatterns(v interface{}) ([]string, error) {
+	switch vv := v.(type) {
+	case string:
+		return []string{vv}, nil
+
+	case []interface{}:
+		patterns := make([]string, 0, len(vv))
+		for _, x := range vv {
+			s, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a string or an array of strings", IncludeKey)
+			}
+
+			patterns = append(patterns, s)
+		}
+
+		return patterns, nil
+
+	default:
+		return nil, fmt.Errorf("%s must be a string or an array of strings", IncludeKey)
+	}
+}
+
+// Returns the files matched by an include pattern appearing in the file at
+// path.
+func resolveInclude(path, pattern string) ([]string, error) {
+	pattern = expandPath(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(path), pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 && !hasMeta(pattern) {
+		return nil, fmt.Errorf("Included file not found")
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+func inChain(path string, chain []string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	for _, p := range chain {
+		pabs, err := filepath.Abs(p)
+		if err != nil {
+			pabs = p
+		}
+
+		if pabs == abs {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,306 @@
+package adaptconf
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+
+// Determines whether references in string values are expanded.
+type InterpolationMode int
+
+const (
+	// String values are passed through unchanged. This is the default.
+	NoInterpolation InterpolationMode = iota
+
+	// References in string values are expanded. Unresolved references expand
+	// to the empty string.
+	Interpolate
+
+	// Like Interpolate, but unresolved references cause loading to fail with an
+	// *UnresolvedError.
+	StrictInterpolate
+)
+
+// Determines whether and how references in string values are expanded when
+// loading configuration files. The following forms are recognised:
+//
+//	${VAR}           The environment variable VAR.
+//	${env:VAR}       The environment variable VAR.
+//	${server.bind}   The value of another key. Any name containing a dot is
+//	                 taken to be a key reference rather than an environment
+//	                 variable.
+//	${NAME:-dflt}    Like ${NAME}, but expands to dflt if NAME is unset or
+//	                 empty. dflt may itself contain references.
+//	$$               A literal "$".
+//
+// A key reference is resolved against the file being loaded, then against the
+// files loaded before it, and failing that, against the current value of the
+// configurable at that path. References in the referenced value are
+// themselves expanded; a reference cycle is an error.
+var Interpolation InterpolationMode
+
+// Returned when a configuration file contains references which cannot be
+// resolved and Interpolation is StrictInterpolate.
+type UnresolvedError struct {
+	Path  string
+	Names []string
+}
+
+func (e *UnresolvedError) Error() string {
+	return fmt.Sprintf("Unresolved references in %s: %s", e.Path, strings.Join(e.Names, ", "))
+}
+
+// Expands references in all string values in m according to Interpolation,
+// returning a new map. earlier are the documents loaded before m.
+func interpolate(path string, m map[string]interface{}, earlier []document) (map[string]interface{}, error) {
+	if Interpolation == NoInterpolation {
+		return m, nil
+	}
+
+	e := &expander{
+		doc:       m,
+		earlier:   earlier,
+		resolving: map[string]bool{},
+	}
+
+	v, err := e.expandValue(m)
+	if err != nil {
+		return nil, fmt.Errorf("Error interpolating %s: %s", path, err)
+	}
+
+	if Interpolation == StrictInterpolate && len(e.unresolved) > 0 {
+		sort.Strings(e.unresolved)
+		return nil, &UnresolvedError{Path: path, Names: e.unresolved}
+	}
+
+	return v.(map[string]interface{}), nil
+}
+
+type expander struct {
+	doc        map[string]interface{}
+	earlier    []document
+	resolving  map[string]bool
+	stack      []string
+	unresolved []string
+}
+
+func (e *expander) expandValue(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case string:
+		return e.expand(vv)
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, x := range vv {
+			y, err := e.expandValue(x)
+			if err != nil {
+				return nil, err
+			}
+
+			m[k] = y
+		}
+
+		return m, nil
+
+	case []interface{}:
+		a := make([]interface{}, len(vv))
+		for i, x := range vv {
+			y, err := e.expandValue(x)
+			if err != nil {
+				return nil, err
+			}
+
+			a[i] = y
+		}
+
+		return a, nil
+
+	default:
+		return v, nil
+	}
+}
+
+func (e *expander) expand(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+
+		case '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated reference in %#v", s)
+			}
+
+			r, err := e.resolve(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+
+			b.WriteString(r)
+			i = end
+
+		default:
+			b.WriteByte('$')
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Returns the index of the brace closing the one at s[start], allowing for
+// nested references in defaults.
+func matchingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func (e *expander) resolve(expr string) (string, error) {
+	name, dflt, hasDflt := expr, "", false
+	if idx := strings.Index(expr, ":-"); idx >= 0 {
+		name, dflt, hasDflt = expr[0:idx], expr[idx+2:], true
+	}
+
+	var v string
+	var ok bool
+	var err error
+	switch {
+	case strings.HasPrefix(name, "env:"):
+		name = name[4:]
+		v, ok = os.LookupEnv(name)
+
+	case strings.Contains(name, "."):
+		v, ok, err = e.lookupKey(name)
+		if err != nil {
+			return "", err
+		}
+
+	default:
+		v, ok = os.LookupEnv(name)
+	}
+
+	if ok && (v != "" || !hasDflt) {
+		return v, nil
+	}
+
+	if hasDflt {
+		return e.expand(dflt)
+	}
+
+	e.unresolved = append(e.unresolved, name)
+	return "", nil
+}
+
+func (e *expander) lookupKey(key string) (string, bool, error) {
+	if e.resolving[key] {
+		return "", false, fmt.Errorf("reference cycle: %s -> %s", strings.Join(e.stack, " -> "), key)
+	}
+
+	path := strings.Split(key, ".")
+	v, ok := lookupDoc(e.doc, path)
+	if !ok {
+		// Documents already loaded have already been expanded.
+		for i := len(e.earlier) - 1; i >= 0; i-- {
+			v, ok = lookupDoc(e.earlier[i].m, path)
+			if ok {
+				return scalarString(v)
+			}
+		}
+
+		return lookupConfigurable(key)
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return scalarString(v)
+	}
+
+	e.resolving[key] = true
+	e.stack = append(e.stack, key)
+	defer func() {
+		delete(e.resolving, key)
+		e.stack = e.stack[0 : len(e.stack)-1]
+	}()
+
+	s, err := e.expand(s)
+	return s, err == nil, err
+}
+
+// Tables cannot be referenced.
+func scalarString(v interface{}) (string, bool, error) {
+	if _, ok := v.(map[string]interface{}); ok {
+		return "", false, nil
+	}
+
+	return fmt.Sprint(v), true, nil
+}
+
+func lookupDoc(m map[string]interface{}, path []string) (interface{}, bool) {
+	v, ok := m[path[0]]
+	if !ok || len(path) == 1 {
+		return v, ok
+	}
+
+	vm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	return lookupDoc(vm, path[1:])
+}
+
+func lookupConfigurable(key string) (string, bool, error) {
+	var cs []configurable.Configurable
+	configurable.Visit(func(c configurable.Configurable) error {
+		cs = append(cs, c)
+		return nil
+	})
+
+	var c configurable.Configurable
+	for _, n := range strings.Split(key, ".") {
+		c = childByName(cs, n)
+		if c == nil {
+			return "", false, nil
+		}
+
+		cs = children(c)
+	}
+
+	v, ok := value(c)
+	if !ok {
+		return "", false, nil
+	}
+
+	return fmt.Sprint(v), true, nil
+}
+
+func value(c configurable.Configurable) (interface{}, bool) {
+	switch v := c.(type) {
+	case interface{ CfValue() interface{} }:
+		return v.CfValue(), true
+	case interface{ CfGetValue() interface{} }:
+		return v.CfGetValue(), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,204 @@
+package adaptconf
+
+import "fmt"
+import "io"
+import "reflect"
+import "regexp"
+import "strings"
+import "strconv"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+// Writes a sample configuration file to w, in the format registered for the
+// given extension, e.g. ".toml" or ".yaml". Every registered configurable is
+// present, commented out and set to its default value, or the zero value of
+// its type if it has none, and preceded by its usage summary line as a
+// comment, except for deprecated configurables.
+// Formats without comments, such as JSON, are not supported.
+func Sample(w io.Writer, ext string) error {
+	f, ok := formatForExt(ext)
+	if !ok {
+		return fmt.Errorf("unknown configuration file format: %q", ext)
+	}
+
+	var top []configurable.Configurable
+	configurable.Visit(func(c configurable.Configurable) error {
+		if c != confFlag {
+			top = append(top, c)
+		}
+		return nil
+	})
+
+	var b strings.Builder
+	var err error
+	switch f.(type) {
+	case tomlFormat:
+		err = sampleTOML(&b, nil, top)
+	case yamlFormat:
+		err = sampleYAML(&b, 0, top)
+	default:
+		return fmt.Errorf("configuration file format does not support comments: %q", ext)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the keys of a table, followed by its subtables, since TOML requires
+// keys to precede subtables.
+func sampleTOML(b *strings.Builder, path []string, cs []configurable.Configurable) error {
+	var groups []configurable.Configurable
+	for _, c := range cs {
+		n, ok := name(c)
+		if !ok {
+			continue
+		}
+
+		if len(children(c)) > 0 {
+			groups = append(groups, c)
+			continue
+		}
+
+		v, ok := sampleValue(c)
+		if !ok {
+			continue
+		}
+
+		enc, err := tomlFormat{}.Encode(map[string]interface{}{n: v})
+		if err != nil {
+			return err
+		}
+
+		sampleUsage(b, "", c)
+		fmt.Fprintf(b, "#%s = %s\n\n", tomlKey(n), tomlValue(enc))
+	}
+
+	for _, g := range groups {
+		n, _ := name(g)
+		p := append(path[0:len(path):len(path)], n)
+		keys := make([]string, len(p))
+		for i := range p {
+			keys[i] = tomlKey(p[i])
+		}
+
+		fmt.Fprintf(b, "[%s]\n", strings.Join(keys, "."))
+		err := sampleTOML(b, p, children(g))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the value from a single line "key = value" produced by the TOML
+// encoder.
+func tomlValue(enc []byte) string {
+	s := strings.TrimSpace(string(enc))
+	i := strings.Index(s, " = ")
+	if i < 0 {
+		return s
+	}
+
+	return s[i+3:]
+}
+
+var reBareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if reBareTOMLKey.MatchString(k) {
+		return k
+	}
+
+	return strconv.Quote(k)
+}
+
+func sampleYAML(b *strings.Builder, depth int, cs []configurable.Configurable) error {
+	indent := strings.Repeat("  ", depth)
+	for _, c := range cs {
+		n, ok := name(c)
+		if !ok {
+			continue
+		}
+
+		if chs := children(c); len(chs) > 0 {
+			enc, err := yamlFormat{}.Encode(map[string]interface{}{n: nil})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(b, "#%s%s:\n", indent, strings.TrimSuffix(strings.TrimSpace(string(enc)), ": null"))
+			err = sampleYAML(b, depth+1, chs)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		v, ok := sampleValue(c)
+		if !ok {
+			continue
+		}
+
+		enc, err := yamlFormat{}.Encode(map[string]interface{}{n: v})
+		if err != nil {
+			return err
+		}
+
+		sampleUsage(b, indent, c)
+		for _, line := range strings.Split(strings.TrimRight(string(enc), "\n"), "\n") {
+			fmt.Fprintf(b, "#%s%s\n", indent, line)
+		}
+		b.WriteString("\n")
+	}
+
+	return nil
+}
+
+func sampleUsage(b *strings.Builder, indent string, c configurable.Configurable) {
+	u, ok := c.(interface {
+		CfUsageSummaryLine() string
+	})
+	if !ok || u.CfUsageSummaryLine() == "" {
+		return
+	}
+
+	for _, line := range strings.Split(u.CfUsageSummaryLine(), "\n") {
+		fmt.Fprintf(b, "%s# %s\n", indent, line)
+	}
+}
+
+// Returns the default value of the configurable, or if it has none, the zero
+// value of its type. The current value is never used, since it may have been
+// set from the command line or environment. Non-empty values of secret
+// configurables are redacted.
+func sampleValue(c configurable.Configurable) (interface{}, bool) {
+	if _, ok := c.(interface {
+		CfSetValue(x interface{}) error
+	}); !ok {
+		return nil, false
+	}
+
+	if _, ok := deprecated(c); ok {
+		return nil, false
+	}
+
+	v, ok := defaultValue(c)
+	if !ok || v == nil {
+		cur, ok2 := value(c)
+		if !ok2 || cur == nil {
+			return nil, false
+		}
+
+		v, ok = reflect.Zero(reflect.TypeOf(cur)).Interface(), true
+	}
+
+	if ok && provenance.IsSecret(c) && v != "" {
+		v = provenance.Redacted
+	}
+
+	return v, ok && v != nil
+}
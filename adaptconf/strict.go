@@ -0,0 +1,223 @@
+package adaptconf
+
+import "fmt"
+import "sort"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+// Determines what happens when a configuration file contains a key which does
+// not correspond to any registered configurable.
+type UnknownKeyMode int
+
+const (
+	// Unknown keys are silently ignored. This is the default.
+	IgnoreUnknownKeys UnknownKeyMode = iota
+
+	// Unknown keys are reported via provenance.Warnf, but loading continues.
+	WarnUnknownKeys
+
+	// Unknown keys cause loading to fail with an *UnknownKeysError. No values
+	// from the offending file are applied.
+	RejectUnknownKeys
+)
+
+// The mode used when loading configuration files.
+var UnknownKeys UnknownKeyMode
+
+// A key in a configuration file not consumed by any registered configurable.
+type UnknownKey struct {
+	// Dotted path of the key, e.g. "example.bnd".
+	Key string
+
+	// Dotted path of the closest known key, or "" if nothing is close enough
+	// to be worth suggesting.
+	Suggestion string
+}
+
+func (k UnknownKey) String() string {
+	if k.Suggestion == "" {
+		return fmt.Sprintf("%#v", k.Key)
+	}
+
+	return fmt.Sprintf("%#v (did you mean %#v?)", k.Key, k.Suggestion)
+}
+
+// Returned when a configuration file contains unknown keys and UnknownKeys is
+// RejectUnknownKeys.
+type UnknownKeysError struct {
+	Path string
+	Keys []UnknownKey
+}
+
+func (e *UnknownKeysError) Error() string {
+	return fmt.Sprintf("Unknown keys in %s: %s", e.Path, joinKeys(e.Keys))
+}
+
+func joinKeys(keys []UnknownKey) string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = k.String()
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// Checks a decoded document against the registered configurables according
+// to UnknownKeys.
+func checkUnknownKeys(path string, m map[string]interface{}) error {
+	if UnknownKeys == IgnoreUnknownKeys {
+		return nil
+	}
+
+	var top []configurable.Configurable
+	configurable.Visit(func(c configurable.Configurable) error {
+		top = append(top, c)
+		return nil
+	})
+
+	keys := findUnknownKeys(top, m)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if UnknownKeys == WarnUnknownKeys {
+		provenance.Warnf("Ignoring unknown keys in %s: %s", path, joinKeys(keys))
+		return nil
+	}
+
+	return &UnknownKeysError{Path: path, Keys: keys}
+}
+
+// Returns every key in m not consumed by the configurables given, with
+// suggestions drawn from the keys which would have been consumed.
+func findUnknownKeys(cs []configurable.Configurable, m map[string]interface{}) []UnknownKey {
+	var known []string
+	for _, c := range cs {
+		knownPaths(nil, c, &known)
+	}
+
+	var unknown []string
+	collectUnknown(nil, cs, m, &unknown)
+
+	keys := make([]UnknownKey, len(unknown))
+	for i, k := range unknown {
+		keys[i] = UnknownKey{Key: k, Suggestion: closest(k, known)}
+	}
+
+	return keys
+}
+
+func collectUnknown(path []string, cs []configurable.Configurable, m map[string]interface{}, unknown *[]string) {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		p := append(path[0:len(path):len(path)], k)
+		c := childByName(cs, k)
+		if c == nil {
+			*unknown = append(*unknown, strings.Join(p, "."))
+			continue
+		}
+
+		// A group consumes its own key but not necessarily those inside it.
+		chs := children(c)
+		vm, ok := m[k].(map[string]interface{})
+		if len(chs) > 0 && ok {
+			collectUnknown(p, chs, vm, unknown)
+		}
+	}
+}
+
+func knownPaths(path []string, c configurable.Configurable, known *[]string) {
+	n, ok := name(c)
+	if !ok {
+		return
+	}
+
+	p := append(path[0:len(path):len(path)], n)
+	*known = append(*known, strings.Join(p, "."))
+	for _, ch := range children(c) {
+		knownPaths(p, ch, known)
+	}
+}
+
+func childByName(cs []configurable.Configurable, n string) configurable.Configurable {
+	for _, c := range cs {
+		cn, ok := name(c)
+		if ok && cn == n {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func children(c configurable.Configurable) []configurable.Configurable {
+	cc, ok := c.(interface {
+		CfChildren() []configurable.Configurable
+	})
+	if !ok {
+		return nil
+	}
+
+	return cc.CfChildren()
+}
+
+// Returns the candidate closest to s, or "" if none is plausibly a misspelling
+// of it.
+func closest(s string, candidates []string) string {
+	best, bestDist := "", -1
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(s), strings.ToLower(c))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+
+	if bestDist < 0 || bestDist > 1+len(s)/4 {
+		return ""
+	}
+
+	return best
+}
+
+// Optimal string alignment distance; like Levenshtein distance, but a
+// transposition of two adjacent characters counts as a single edit.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = minInt(minInt(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,338 @@
+package adaptconf
+
+import "os"
+import "fmt"
+import "sort"
+import "time"
+import "path/filepath"
+import "github.com/fsnotify/fsnotify"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+// The outcome of a reload.
+type ReloadResult struct {
+	// Dotted paths of the keys whose values were changed, in the order in
+	// which they were applied.
+	Changed []string
+
+	// Dotted paths of the keys whose values have changed but which were not
+	// applied because they require a restart to take effect. These continue
+	// to be reported by subsequent reloads until the change is reverted.
+	RestartRequired []string
+
+	// The files which contributed to the configuration after the reload.
+	Files []string
+}
+
+// Repeats the most recent successful call to LoadPath, LoadPaths or Load.
+//
+// All files are read and checked before any values are applied; if this
+// fails, the error is returned and the running configuration is left as it
+// was. Otherwise only values which differ from those previously loaded are
+// applied, so that change notifications are only delivered for values which
+// actually changed. Values which were set from a configuration file but no
+// longer appear in any file revert to their defaults.
+//
+// Values set from a higher priority source, such as a command line flag, are
+// not affected. Nor are configurables which require a restart to take effect
+// (see cflag.Restart); these are listed in the result if their configured
+// values differ from those in effect.
+//
+// If some values cannot be set, the remaining values are still applied, and
+// the result is returned along with an error describing the failures.
+func Reload() (*ReloadResult, error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	if lastSpec == nil {
+		return nil, fmt.Errorf("Nothing to reload")
+	}
+
+	lastUndo = nil
+	docs, err := lastSpec.read()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &undo{saved: map[configurable.Configurable]*savedState{}}
+	changed, restart, errs := commit(docs, lastAssignments, u)
+	lastUndo = u
+
+	res := &ReloadResult{
+		RestartRequired: restart,
+		Files:           append([]string(nil), confPaths...),
+	}
+	for _, a := range changed {
+		res.Changed = append(res.Changed, a.key)
+	}
+
+	return res, joinErrors(errs)
+}
+
+// If non-zero, watched files are polled at this interval rather than relying
+// on filesystem notifications. Polling is also used, at DefaultPollInterval,
+// if filesystem notifications are unavailable.
+var WatchPollInterval time.Duration
+
+// The polling interval used if filesystem notifications are unavailable and
+// WatchPollInterval is zero.
+const DefaultPollInterval = 2 * time.Second
+
+// After a change is seen, reloading waits until no further changes have been
+// seen for this long, so that a burst of changes results in a single reload.
+var WatchDebounce = 250 * time.Millisecond
+
+// Watches the files which contributed to the configuration and reloads it
+// when they change.
+type Watcher struct {
+	onReload func(res *ReloadResult, err error)
+	fsw      *fsnotify.Watcher
+	dirs     map[string]struct{}
+	state    map[string]fileState
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Starts watching for changes to the configuration loaded by the most recent
+// call to LoadPath, LoadPaths or Load, and calls Reload when they occur.
+//
+// The files watched are those which contributed to the configuration,
+// including drop-in fragments and included files, along with the candidate
+// paths and drop-in directories, so that newly created files are noticed.
+// The set of files watched is updated after each reload.
+//
+// After each reload attempt, onReload is called from the watcher's goroutine
+// with the results of Reload. onReload may be nil.
+func Watch(onReload func(res *ReloadResult, err error)) (*Watcher, error) {
+	w := &Watcher{
+		onReload: onReload,
+		dirs:     map[string]struct{}{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	paths := watchedPaths()
+	if paths == nil {
+		return nil, fmt.Errorf("Nothing to watch")
+	}
+
+	w.state = statPaths(paths)
+
+	pollInterval := WatchPollInterval
+	if pollInterval == 0 {
+		fsw, err := fsnotify.NewWatcher()
+		if err == nil {
+			w.fsw = fsw
+			w.watchDirs(paths)
+		} else {
+			pollInterval = DefaultPollInterval
+		}
+	}
+
+	go w.run(pollInterval)
+	return w, nil
+}
+
+// Stops watching.
+func (w *Watcher) Close() error {
+	close(w.stop)
+	<-w.done
+
+	if w.fsw != nil {
+		return w.fsw.Close()
+	}
+
+	return nil
+}
+
+func (w *Watcher) run(pollInterval time.Duration) {
+	defer close(w.done)
+
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	if w.fsw != nil {
+		events = w.fsw.Events
+		errors = w.fsw.Errors
+	}
+
+	var poll <-chan time.Time
+	if pollInterval > 0 {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	var debounce *time.Timer
+	var fire <-chan time.Time
+	defer func() {
+		if debounce != nil {
+			debounce.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+
+		case <-events:
+			// Events are only used as a hint; whether anything relevant has
+			// changed is determined by comparing file states.
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.NewTimer(WatchDebounce)
+			fire = debounce.C
+
+		case err := <-errors:
+			provenance.Warnf("Error watching configuration files: %v", err)
+
+		case <-poll:
+			if fire == nil && w.changed() {
+				debounce = time.NewTimer(WatchDebounce)
+				fire = debounce.C
+			}
+
+		case <-fire:
+			fire = nil
+			if w.changed() {
+				w.reload()
+			}
+		}
+	}
+}
+
+func (w *Watcher) changed() bool {
+	return !sameState(w.state, statPaths(watchedPathsFrom(w.state)))
+}
+
+func (w *Watcher) reload() {
+	res, err := Reload()
+
+	paths := watchedPaths()
+	w.state = statPaths(paths)
+	if w.fsw != nil {
+		w.watchDirs(paths)
+	}
+
+	if w.onReload != nil {
+		w.onReload(res, err)
+	}
+}
+
+// Watches the directories containing the paths given, and the paths
+// themselves where they are directories. Watching directories rather than
+// files means that files replaced by renaming, as many editors do, continue
+// to be watched.
+func (w *Watcher) watchDirs(paths []string) {
+	for _, path := range paths {
+		dir := path
+		if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+			dir = filepath.Dir(path)
+		}
+
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+
+		if w.fsw.Add(dir) == nil {
+			w.dirs[dir] = struct{}{}
+		}
+	}
+}
+
+// Returns the paths whose state determines whether the configuration needs
+// reloading, or nil if nothing has been loaded.
+func watchedPaths() []string {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	if lastSpec == nil {
+		return nil
+	}
+
+	set := map[string]struct{}{}
+	for _, path := range confPaths {
+		set[path] = struct{}{}
+	}
+
+	var candidates []string
+	if lastSpec.path != "" {
+		candidates = []string{lastSpec.path}
+	} else if confPath := confFlag.Value(); confPath != "" {
+		candidates = []string{confPath}
+	} else {
+		for _, path := range lastSpec.paths {
+			candidates = append(candidates, expandPath(path))
+		}
+	}
+
+	for _, path := range candidates {
+		set[path] = struct{}{}
+		if dir := DropInDir(path); dir != "" {
+			set[dir] = struct{}{}
+		}
+
+		dropIns, _ := dropInPaths(path)
+		for _, dropIn := range dropIns {
+			set[dropIn] = struct{}{}
+		}
+	}
+
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statPaths(paths []string) map[string]fileState {
+	state := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			state[path] = fileState{}
+			continue
+		}
+
+		state[path] = fileState{
+			exists:  true,
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+		}
+	}
+
+	return state
+}
+
+func watchedPathsFrom(state map[string]fileState) []string {
+	paths := make([]string, 0, len(state))
+	for path := range state {
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+func sameState(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, sa := range a {
+		sb, ok := b[path]
+		if !ok || sa.exists != sb.exists || sa.size != sb.size || !sa.modTime.Equal(sb.modTime) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package adaptconf
+
+import "os"
+import "time"
+import "testing"
+import "path/filepath"
+import "gopkg.in/hlandau/easyconfig.v1/cflag"
+
+func TestWatchPolling(t *testing.T) {
+	g := cflag.NewGroup(nil, "watchtest")
+	level := cflag.String(g, "level", "info", "Log level")
+
+	interval, debounce := WatchPollInterval, WatchDebounce
+	WatchPollInterval, WatchDebounce = 10*time.Millisecond, 100*time.Millisecond
+	defer func() { WatchPollInterval, WatchDebounce = interval, debounce }()
+
+	path := filepath.Join(t.TempDir(), "watch.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[watchtest]\nlevel = \"debug\"\n")
+	err := LoadPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan *ReloadResult, 10)
+	w, err := Watch(func(res *ReloadResult, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		reloads <- res
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// A burst of changes within the debounce interval results in one reload.
+	for _, l := range []string{"warn", "error", "trace"} {
+		write("[watchtest]\nlevel = \"" + l + "\"\n")
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	select {
+	case res := <-reloads:
+		if len(res.Changed) != 1 || res.Changed[0] != "watchtest.level" {
+			t.Errorf("unexpected changed keys: %v", res.Changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration not reloaded")
+	}
+
+	if level.Value() != "trace" {
+		t.Errorf("unexpected value after reload: %#v", level.Value())
+	}
+
+	select {
+	case res := <-reloads:
+		t.Errorf("unexpected second reload: %+v", res)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
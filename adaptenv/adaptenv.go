@@ -2,31 +2,71 @@
 package adaptenv
 
 import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/manual"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
 import "os"
+import "sync"
+import "strings"
+
+// The environment variable values most recently applied, so that Reload can
+// skip those which have not changed.
+var appliedMu sync.Mutex
+var applied = map[configurable.Configurable]string{}
 
 // Loads values from environment variables into any configurables which expose
 // CfEnvVarName() string. Priorities are checked.
 func Adapt() {
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+
 	configurable.Visit(func(c configurable.Configurable) error {
-		adaptRecursive(c)
+		adaptRecursive(nil, c, nil)
 		return nil
 	})
 }
 
-func adaptRecursive(c configurable.Configurable) {
+// Like Adapt, but only sets configurables whose environment variable has
+// changed since it was last applied. Configurables whose environment variable
+// has been unset are left unchanged, as are configurables which require a
+// restart for changes to take effect; the dotted paths of the latter are
+// returned if their environment variable has changed.
+func Reload() (restartRequired []string) {
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+
+	r := &reload{}
+	configurable.Visit(func(c configurable.Configurable) error {
+		adaptRecursive(nil, c, r)
+		return nil
+	})
+
+	return r.restartRequired
+}
+
+// State for a reload. nil when adapting for the first time.
+type reload struct {
+	restartRequired []string
+}
+
+func adaptRecursive(path []string, c configurable.Configurable, r *reload) {
+	n, ok := name(c)
+	if ok {
+		path = append(path[0:len(path):len(path)], n)
+	}
+
 	cc, ok := c.(interface {
 		CfChildren() []configurable.Configurable
 	})
 	if ok {
 		for _, ch := range cc.CfChildren() {
-			adaptRecursive(ch)
+			adaptRecursive(path, ch, r)
 		}
 	}
 
-	adapt(c)
+	adapt(path, c, r)
 }
 
-func adapt(c configurable.Configurable) {
+func adapt(path []string, c configurable.Configurable, r *reload) {
 	cenv, ok := c.(interface {
 		CfEnvVarName() string
 		CfSetValue(x interface{}) error
@@ -45,22 +85,115 @@ func adapt(c configurable.Configurable) {
 		return
 	}
 
+	if prev, ok := applied[c]; ok && r != nil && prev == v {
+		return
+	}
+
+	// Values for deprecated configurables are applied to their replacements.
+	// applied is still keyed by the deprecated configurable, since that is
+	// where the value came from.
+	src := c
+	c = forward(envVarName, c)
+	cs, ok := c.(interface {
+		CfSetValue(x interface{}) error
+	})
+	if !ok {
+		return
+	}
+
 	cprio, ok := c.(interface {
 		CfGetPriority() configurable.Priority
 		CfSetPriority(priority configurable.Priority)
 	})
 	if ok {
 		if cprio.CfGetPriority() > configurable.EnvPriority {
+			record(c, envVarName, v, false)
 			return
 		}
 	}
 
-	err := cenv.CfSetValue(v)
+	if r != nil && restartRequired(c) {
+		r.restartRequired = append(r.restartRequired, strings.Join(path, "."))
+		record(c, envVarName, v, false)
+		return
+	}
+
+	err := cs.CfSetValue(v)
 	if err != nil {
+		record(c, envVarName, v, false)
 		return
 	}
 
+	applied[src] = v
+	record(c, envVarName, v, true)
+
 	if ok {
 		cprio.CfSetPriority(configurable.EnvPriority)
 	}
 }
+
+// Returns the configurable to which a value for c should be applied. This is
+// c itself, unless c is deprecated in favour of another configurable, in
+// which case it is the replacement. Warns if c is deprecated.
+func forward(envVarName string, c configurable.Configurable) configurable.Configurable {
+	cd, ok := c.(interface {
+		CfDeprecated() (string, bool)
+	})
+	if !ok {
+		return c
+	}
+
+	replacement, ok := cd.CfDeprecated()
+	if !ok {
+		return c
+	}
+
+	if replacement == "" {
+		provenance.Warnf("Environment variable %s is deprecated", envVarName)
+		return c
+	}
+
+	rc := manual.ByName(replacement)
+	if rc == nil {
+		provenance.Warnf("Environment variable %s is deprecated; use %s instead", envVarName, replacement)
+		return c
+	}
+
+	if re, ok := rc.(interface {
+		CfEnvVarName() string
+	}); ok && re.CfEnvVarName() != "" {
+		provenance.Warnf("Environment variable %s is deprecated; use %s instead", envVarName, re.CfEnvVarName())
+	} else {
+		provenance.Warnf("Environment variable %s is deprecated; set %s instead", envVarName, replacement)
+	}
+
+	return rc
+}
+
+func record(c configurable.Configurable, envVarName, v string, applied bool) {
+	provenance.Record(c, provenance.Source{
+		Kind:     provenance.Env,
+		Location: envVarName,
+		Value:    v,
+		Applied:  applied,
+	})
+}
+
+func restartRequired(c configurable.Configurable) bool {
+	cr, ok := c.(interface {
+		CfRestartRequired() bool
+	})
+
+	return ok && cr.CfRestartRequired()
+}
+
+func name(c configurable.Configurable) (name string, ok bool) {
+	v, ok := c.(interface {
+		CfName() string
+	})
+	if !ok {
+		return
+	}
+
+	return v.CfName(), true
+}
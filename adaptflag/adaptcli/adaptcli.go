@@ -0,0 +1,320 @@
+// Package adaptcli adapts registered configurables to the flags of
+// urfave/cli applications.
+//
+// Configurables whose values are strings, ints or bools are represented by
+// the corresponding typed cli flags, so that help output shows their types
+// and defaults as usual. Values given on the command line are applied to the
+// configurables in the application's Before hook, after which configuration
+// is loaded from environment variables and configuration files, so that the
+// usual priorities are preserved.
+package adaptcli
+
+import "fmt"
+import "os"
+import "strconv"
+import "sync"
+import "github.com/urfave/cli/v2"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/adaptconf"
+import "gopkg.in/hlandau/easyconfig.v1/adaptenv"
+import "gopkg.in/hlandau/easyconfig.v1/adaptflag"
+
+// Returns cli flags for c and its descendants, or if c is nil, for all
+// registered configurables. Flag names are formed as described for
+// adaptflag.AdaptTree.
+//
+// The returned function applies values given on the command line to the
+// configurables, and should be called from a Before hook. Typed flags have
+// EnvVars populated from the configurable's environment variable, if any, so
+// that it is shown in help output; values which cli takes from the
+// environment are left to the adaptenv package.
+//
+// Returns an error if two configurables want the same flag name or alias;
+// the flags for the remaining configurables are still returned.
+func Flags(c configurable.Configurable) ([]cli.Flag, func(ctx *cli.Context) error, error) {
+	b := &binder{}
+	err := adaptflag.AdaptTree(b, c, b.add)
+	return b.flags, b.apply, err
+}
+
+// Adds flags for c and its descendants, or if c is nil, all registered
+// configurables, to app, and arranges for values to be loaded in app's Before
+// hook. Values given on the command line are applied first, then values from
+// environment variables, then from configuration files using
+// adaptconf.Load(programName). If programName is empty, no configuration
+// files are loaded. Any existing Before hook is called afterwards.
+//
+// Attach may be called more than once for the same app, for example to add
+// different subtrees; the Before hook is installed only once, and
+// configurables already attached to app are skipped. Configuration files are
+// loaded using the first non-empty programName given. Returns an error if a
+// flag of the same name is already defined.
+func Attach(app *cli.App, c configurable.Configurable, programName string) error {
+	b := &binder{existing: app.Flags}
+	err := adaptflag.AdaptTree(app, c, b.add)
+	app.Flags = append(app.Flags, b.flags...)
+
+	attachedMu.Lock()
+	defer attachedMu.Unlock()
+
+	a, ok := attachedTo[app]
+	if !ok {
+		a = &attachment{}
+		attachedTo[app] = a
+
+		prev := app.Before
+		app.Before = func(ctx *cli.Context) error {
+			err := a.before(ctx)
+			if err != nil {
+				return err
+			}
+
+			if prev != nil {
+				return prev(ctx)
+			}
+
+			return nil
+		}
+	}
+
+	a.binders = append(a.binders, b)
+	if a.programName == "" {
+		a.programName = programName
+	}
+
+	return err
+}
+
+// The state of each app passed to Attach, so that its Before hook is only
+// wrapped once.
+var attachedMu sync.Mutex
+var attachedTo = map[*cli.App]*attachment{}
+
+type attachment struct {
+	binders     []*binder
+	programName string
+}
+
+// Applies values given on the command line, then loads values from
+// environment variables and configuration files.
+func (a *attachment) before(ctx *cli.Context) error {
+	attachedMu.Lock()
+	binders := a.binders
+	programName := a.programName
+	attachedMu.Unlock()
+
+	for _, b := range binders {
+		err := b.apply(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	adaptenv.Adapt()
+	if programName != "" {
+		err := adaptconf.Load(programName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type binder struct {
+	existing []cli.Flag
+	flags    []cli.Flag
+	bindings []*binding
+}
+
+// A typed flag whose value must be copied to the configurable.
+type binding struct {
+	name   string
+	envVar string
+	value  adaptflag.Value
+
+	// Returns the flag's value, formatted as the environment variable would
+	// be if it were the source of the value.
+	get func(ctx *cli.Context) string
+
+	// Parses the value of the environment variable in the same way as the
+	// flag.
+	parse func(s string) (string, error)
+}
+
+func (b *binder) add(info adaptflag.Info) error {
+	name := info.FlagName()
+	var err error
+	var aliases []string
+	for i, n := range adaptflag.Names(info) {
+		if b.defined(n) {
+			if i == 0 {
+				return fmt.Errorf("flag already defined: %s", name)
+			}
+			if err == nil {
+				err = fmt.Errorf("flag already defined: %s (alias of %s)", n, name)
+			}
+			continue
+		}
+
+		if i > 0 {
+			aliases = append(aliases, n)
+		}
+	}
+
+	envVar := envVarName(info.Configurable)
+	var envVars []string
+	if envVar != "" {
+		envVars = []string{envVar}
+	}
+
+	bd := &binding{
+		name:   name,
+		envVar: envVar,
+		value:  info.Value,
+		parse:  func(s string) (string, error) { return s, nil },
+	}
+
+	// The type of the flag is determined by the current value, since the
+	// configurable may have no default.
+	var v interface{}
+	if vg, ok := info.Value.(interface {
+		Get() interface{}
+	}); ok {
+		v = vg.Get()
+	}
+	dv, _ := defaultValue(info.Configurable)
+
+	switch v.(type) {
+	case string:
+		dflt, _ := dv.(string)
+		b.flags = append(b.flags, &cli.StringFlag{
+			Name:        name,
+			Aliases:     aliases,
+			Hidden:      info.Hidden,
+			Usage:       info.Usage,
+			Value:       dflt,
+			DefaultText: info.DefaultValueString,
+			EnvVars:     envVars,
+		})
+		bd.get = func(ctx *cli.Context) string { return ctx.String(name) }
+
+	case int:
+		dflt, _ := dv.(int)
+		b.flags = append(b.flags, &cli.IntFlag{
+			Name:    name,
+			Aliases: aliases,
+			Hidden:  info.Hidden,
+			Usage:   info.Usage,
+			Value:   dflt,
+			EnvVars: envVars,
+		})
+		bd.get = func(ctx *cli.Context) string { return strconv.Itoa(ctx.Int(name)) }
+		bd.parse = func(s string) (string, error) {
+			n, err := strconv.ParseInt(s, 0, 64)
+			return strconv.Itoa(int(n)), err
+		}
+
+	case bool:
+		dflt, _ := dv.(bool)
+		b.flags = append(b.flags, &cli.BoolFlag{
+			Name:    name,
+			Aliases: aliases,
+			Hidden:  info.Hidden,
+			Usage:   info.Usage,
+			Value:   dflt,
+			EnvVars: envVars,
+		})
+		bd.get = func(ctx *cli.Context) string { return strconv.FormatBool(ctx.Bool(name)) }
+		bd.parse = func(s string) (string, error) {
+			x, err := strconv.ParseBool(s)
+			return strconv.FormatBool(x), err
+		}
+
+	default:
+		// Generic flags set the configurable directly. cli would also set
+		// them from the environment with flag priority, so EnvVars is left
+		// empty.
+		b.flags = append(b.flags, &cli.GenericFlag{
+			Name:        name,
+			Aliases:     aliases,
+			Hidden:      info.Hidden,
+			Usage:       info.Usage,
+			Value:       info.Value,
+			DefaultText: info.DefaultValueString,
+		})
+		return err
+	}
+
+	b.bindings = append(b.bindings, bd)
+	return err
+}
+
+// Reports whether a flag with the given name or alias has been defined.
+func (b *binder) defined(name string) bool {
+	for _, f := range append(b.existing[0:len(b.existing):len(b.existing)], b.flags...) {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (b *binder) apply(ctx *cli.Context) error {
+	for _, bd := range b.bindings {
+		err := bd.apply(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (bd *binding) apply(ctx *cli.Context) error {
+	if !ctx.IsSet(bd.name) {
+		return nil
+	}
+
+	// cli does not say whether the value came from the command line or the
+	// environment. If it matches the environment variable, assume the latter
+	// and leave it to adaptenv, which applies the correct priority.
+	s := bd.get(ctx)
+	if ev, ok := os.LookupEnv(bd.envVar); ok && bd.envVar != "" {
+		if es, err := bd.parse(ev); err == nil && es == s {
+			return nil
+		}
+	}
+
+	err := bd.value.Set(s)
+	if err != nil {
+		return fmt.Errorf("invalid value for flag %s: %v", bd.name, err)
+	}
+
+	return nil
+}
+
+func envVarName(c configurable.Configurable) string {
+	v, ok := c.(interface {
+		CfEnvVarName() string
+	})
+	if !ok {
+		return ""
+	}
+
+	return v.CfEnvVarName()
+}
+
+func defaultValue(c configurable.Configurable) (dflt interface{}, ok bool) {
+	v, ok := c.(interface {
+		CfDefaultValue() interface{}
+	})
+	if !ok {
+		return nil, false
+	}
+
+	return v.CfDefaultValue(), true
+}
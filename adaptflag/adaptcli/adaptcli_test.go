@@ -0,0 +1,114 @@
+package adaptcli
+
+import "os"
+import "testing"
+import "github.com/urfave/cli/v2"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/cstruct"
+
+func TestAttach(t *testing.T) {
+	type config struct {
+		Bind    string `default:":80" usage:"Address to bind to" env:"ADAPTCLI_TEST_BIND"`
+		Workers int    `default:"4" usage:"Number of workers" env:"ADAPTCLI_TEST_WORKERS"`
+		Verbose bool   `usage:"Verbose output"`
+	}
+
+	var cfg config
+	g := cstruct.MustNew(&cfg, "clitest")
+	configurable.Register(g)
+
+	os.Setenv("ADAPTCLI_TEST_BIND", ":8080")
+	os.Setenv("ADAPTCLI_TEST_WORKERS", "8")
+	defer os.Unsetenv("ADAPTCLI_TEST_BIND")
+	defer os.Unsetenv("ADAPTCLI_TEST_WORKERS")
+
+	// The flag's default is the configurable's default, not its current
+	// value.
+	cfg.Workers = 6
+
+	ran := false
+	befores := 0
+	app := &cli.App{
+		Name: "prog",
+		Before: func(ctx *cli.Context) error {
+			befores++
+			return nil
+		},
+		Action: func(ctx *cli.Context) error {
+			ran = true
+			return nil
+		},
+	}
+
+	err := Attach(app, g, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Attach(app, g, "")
+	if err != nil {
+		t.Errorf("attaching twice should not fail: %v", err)
+	}
+
+	var bindFlag *cli.StringFlag
+	var workersFlag *cli.IntFlag
+	for _, f := range app.Flags {
+		switch tf := f.(type) {
+		case *cli.StringFlag:
+			if tf.Name == "clitest.bind" {
+				bindFlag = tf
+			}
+		case *cli.IntFlag:
+			if tf.Name == "clitest.workers" {
+				workersFlag = tf
+			}
+		}
+	}
+	if bindFlag == nil || len(bindFlag.EnvVars) != 1 || bindFlag.EnvVars[0] != "ADAPTCLI_TEST_BIND" {
+		t.Fatalf("expected typed flag with environment variable: %#v", bindFlag)
+	}
+	if workersFlag == nil || workersFlag.Value != 4 {
+		t.Fatalf("expected typed flag with default value: %#v", workersFlag)
+	}
+	if len(app.Flags) != 3 {
+		t.Errorf("unexpected flags after attaching twice: %v", app.Flags)
+	}
+
+	err = app.Run([]string{"prog", "--clitest.workers=16", "--clitest.verbose"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if befores != 1 {
+		t.Errorf("existing Before hook called %d times", befores)
+	}
+	if !ran || cfg.Bind != ":8080" || cfg.Workers != 16 || !cfg.Verbose {
+		t.Errorf("unexpected result: %v %#v", ran, cfg)
+	}
+
+	// The value from the environment variable has environment priority.
+	p := g.(interface {
+		CfChildren() []configurable.Configurable
+	}).CfChildren()
+	if prio := p[0].(interface {
+		CfGetPriority() configurable.Priority
+	}).CfGetPriority(); prio != configurable.EnvPriority {
+		t.Errorf("unexpected priority for value from environment: %v", prio)
+	}
+}
+
+func TestFlagsConflict(t *testing.T) {
+	var cfg struct {
+		Bind   string `usage:"Address to bind to" alias:"addr"`
+		Listen string `usage:"Address to listen on" alias:"addr"`
+	}
+
+	g := cstruct.MustNew(&cfg, "conflicttest")
+	flags, _, err := Flags(g)
+	if err == nil {
+		t.Error("expected error for conflicting aliases")
+	}
+	if len(flags) != 2 {
+		t.Errorf("expected remaining flags to be returned despite conflict: %v", flags)
+	}
+}
@@ -0,0 +1,138 @@
+// Package adaptcobra adapts registered configurables to the flags of
+// spf13/cobra commands.
+//
+// Configurables, or subtrees of the configurable graph, are attached to
+// particular commands using Attach. Load then arranges for configuration to
+// be loaded from environment variables and configuration files once cobra
+// has parsed the command line, so that values set using flags take priority
+// as usual.
+package adaptcobra
+
+import "fmt"
+import "github.com/spf13/cobra"
+import "github.com/spf13/pflag"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/adaptconf"
+import "gopkg.in/hlandau/easyconfig.v1/adaptenv"
+import "gopkg.in/hlandau/easyconfig.v1/adaptflag"
+
+// Registers c and its descendants as flags of cmd, or if c is nil, all
+// registered configurables. If persistent is true, the flags are registered
+// with cmd.PersistentFlags() and so are also accepted by its subcommands;
+// otherwise they are registered with cmd.Flags().
+//
+// Flag names are formed as described for adaptflag.AdaptTree. Boolean
+// configurables also get a --no-<name> counterpart; see adaptflag.Negation.
+// Aliases are registered as hidden flags. Configurables
+// already attached to the same flag set are skipped. Returns an error if a
+// flag of the same name is already defined; the remaining configurables are
+// still registered. Duplicate short names are also reported as errors.
+func Attach(cmd *cobra.Command, c configurable.Configurable, persistent bool) error {
+	fs := cmd.Flags()
+	if persistent {
+		fs = cmd.PersistentFlags()
+	}
+
+	return AdaptToFlagSet(fs, c)
+}
+
+// Registers c and its descendants as flags with the given spf13/pflag
+// FlagSet, or if c is nil, all registered configurables. See Attach.
+func AdaptToFlagSet(fs *pflag.FlagSet, c configurable.Configurable) error {
+	return adaptflag.AdaptTree(fs, c, func(info adaptflag.Info) error {
+		name := info.FlagName()
+		if fs.Lookup(name) != nil {
+			return fmt.Errorf("flag already defined: %s", name)
+		}
+
+		v, ok := info.Value.(pflag.Value)
+		if !ok {
+			return fmt.Errorf("flag value does not support spf13/pflag: %s", name)
+		}
+
+		var err error
+		short := ""
+		if info.Short != 0 {
+			short = string(info.Short)
+			if len(short) != 1 {
+				err = fmt.Errorf("short name of flag %s must be a single byte: %q", name, short)
+				short = ""
+			} else if fs.ShorthandLookup(short) != nil {
+				err = fmt.Errorf("short flag -%s already defined (wanted by %s)", short, name)
+				short = ""
+			}
+		}
+
+		isBool := false
+		if vb, ok := info.Value.(interface {
+			IsBoolFlag() bool
+		}); ok {
+			isBool = vb.IsBoolFlag()
+		}
+
+		f := fs.VarPF(v, name, short, info.Usage)
+		f.Hidden = info.Hidden
+		if isBool {
+			f.NoOptDefVal = "true"
+		}
+
+		for _, alias := range info.Aliases {
+			if fs.Lookup(alias) != nil {
+				if err == nil {
+					err = fmt.Errorf("flag already defined: %s (alias of %s)", alias, name)
+				}
+				continue
+			}
+
+			av, _ := adaptflag.NamedValue(info, alias).(pflag.Value)
+			f := fs.VarPF(av, alias, "", info.Usage)
+			f.Hidden = true
+			if isBool {
+				f.NoOptDefVal = "true"
+			}
+		}
+
+		if neg, nv, ok := adaptflag.Negation(info); ok && fs.Lookup(neg) == nil {
+			if nv, ok := nv.(pflag.Value); ok {
+				f := fs.VarPF(nv, neg, "", fmt.Sprintf("Negates --%s", name))
+				f.Hidden = info.Hidden
+				f.NoOptDefVal = "true"
+			}
+		}
+
+		return err
+	})
+}
+
+// Arranges for values to be loaded from environment variables, and from
+// configuration files using adaptconf.Load(programName), before cmd or any of
+// its subcommands runs. If programName is empty, no configuration files are
+// loaded.
+//
+// Loading is done in cmd's PersistentPreRunE, after cobra has parsed the
+// command line, and so values set using flags take priority. Any existing
+// PersistentPreRunE or PersistentPreRun of cmd is called afterwards. Note that
+// cobra only runs the PersistentPreRunE of the nearest command which has one,
+// so subcommands which set their own should be passed to Load as well.
+func Load(cmd *cobra.Command, programName string) {
+	prevE := cmd.PersistentPreRunE
+	prev := cmd.PersistentPreRun
+	cmd.PersistentPreRunE = func(c *cobra.Command, args []string) error {
+		adaptenv.Adapt()
+		if programName != "" {
+			err := adaptconf.Load(programName)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case prevE != nil:
+			return prevE(c, args)
+		case prev != nil:
+			prev(c, args)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package adaptcobra
+
+import "testing"
+import "github.com/spf13/cobra"
+import "gopkg.in/hlandau/easyconfig.v1/cflag"
+
+func TestAttach(t *testing.T) {
+	g := cflag.NewGroup(&cflag.NoReg, "server")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+	verbose := cflag.Bool(g, "verbose", false, "Verbose output")
+
+	root := &cobra.Command{Use: "prog"}
+	ran := false
+	serve := &cobra.Command{
+		Use: "serve",
+		Run: func(cmd *cobra.Command, args []string) {
+			ran = true
+		},
+	}
+	root.AddCommand(serve)
+
+	err := Attach(serve, g, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Load(root, "")
+
+	root.SetArgs([]string{"serve", "--server.bind=:8080", "--server.verbose"})
+	err = root.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ran || bind.Value() != ":8080" || !verbose.Value() {
+		t.Errorf("unexpected result: %v %#v %v", ran, bind.Value(), verbose.Value())
+	}
+
+	if root.Flags().Lookup("server.bind") != nil {
+		t.Error("flag attached to wrong command")
+	}
+
+	err = Attach(serve, g, false)
+	if err != nil {
+		t.Errorf("attaching twice should not fail: %v", err)
+	}
+}
@@ -1,23 +1,104 @@
 // Package adaptflag adapts registered configurables to common flag parsing
 // packages, thereby making configurables configurable from the command line.
+//
+// Support for the ogier/pflag and kingpin packages can be omitted from a
+// binary using the build tags noadaptpflag and noadaptkingpin respectively.
+//
+// Help output which groups flags by the configurable groups containing them
+// can be obtained by replacing the flag package's usage function:
+//
+//	flag.Usage = adaptflag.Usage(flag.CommandLine)
+//
+// Flags are named by the dotted path of the groups containing the
+// configurable, matching its configuration file key, e.g. --outer.inner.bind
+// for a flag "bind" in a group "inner" nested within a group "outer". Earlier
+// versions omitted all but the innermost group, naming this flag
+// --inner.bind; to keep accepting the old name, give the flag an alias (see
+// cflag.Alias).
 package adaptflag
 
 import "fmt"
 import "flag"
-import "github.com/ogier/pflag"
-import "gopkg.in/alecthomas/kingpin.v2"
+import "os"
+import "sync"
 import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/manual"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
 import "strings"
 
 var shortFlags = map[string]rune{}
-var shortFlagsReverse = map[rune]string{}
 
+// Gives the flag with the given dotted name a single-character short name.
+// If two flags are given the same short name, adapting them to a flag set
+// fails with an error.
+//
+// Deprecated: Declare short names on the configurable instead, using
+// cflag.Short or the cstruct short tag.
 func MapShort(name string, s rune) {
 	shortFlags[name] = s
-	if _, ok := shortFlagsReverse[s]; ok {
-		panic(fmt.Sprintf("short flag already mapped: %#v=%#v", name, s))
+}
+
+func envVarName(c configurable.Configurable) string {
+	v, ok := c.(interface {
+		CfEnvVarName() string
+	})
+	if !ok {
+		return ""
+	}
+
+	return v.CfEnvVarName()
+}
+
+func enumValues(c configurable.Configurable) []string {
+	v, ok := c.(interface {
+		CfEnumValues() []string
+	})
+	if !ok {
+		return nil
+	}
+
+	return v.CfEnumValues()
+}
+
+func hidden(c configurable.Configurable) bool {
+	v, ok := c.(interface {
+		CfHidden() bool
+	})
+
+	return ok && v.CfHidden()
+}
+
+func deprecated(c configurable.Configurable) (replacement string, ok bool) {
+	v, ok := c.(interface {
+		CfDeprecated() (string, bool)
+	})
+	if !ok {
+		return "", false
+	}
+
+	return v.CfDeprecated()
+}
+
+func shortName(c configurable.Configurable) rune {
+	v, ok := c.(interface {
+		CfShortName() rune
+	})
+	if !ok {
+		return 0
+	}
+
+	return v.CfShortName()
+}
+
+func aliases(c configurable.Configurable) []string {
+	v, ok := c.(interface {
+		CfAliases() []string
+	})
+	if !ok {
+		return nil
 	}
-	shortFlagsReverse[s] = name
+
+	return v.CfAliases()
 }
 
 func name(c configurable.Configurable) (name string, ok bool) {
@@ -57,6 +138,24 @@ var errNotSupported = fmt.Errorf("not supported")
 
 type value struct {
 	c configurable.Configurable
+
+	// The flag name, for recording provenance.
+	flagName string
+
+	// The path of the group containing the configurable, for grouping help.
+	path []string
+
+	// Whether values are positional arguments rather than flags, for recording
+	// provenance.
+	arg bool
+
+	// The flag as spelt on the command line, e.g. "-b", if it was registered
+	// under a name other than flagName. See NamedValue.
+	spelling string
+
+	// The FlagSet the value is registered with, if it accepts flags with
+	// either one dash or two, so that the one used can be found. See Parse.
+	fs *flag.FlagSet
 }
 
 // The flag package uses this to get the default value.
@@ -66,10 +165,150 @@ func (v *value) String() string {
 		return "[configurable]"
 	}
 
+	if provenance.IsSecret(v.c) && dflt != "" {
+		return provenance.Redacted
+	}
+
 	return fmt.Sprintf("%#v", dflt)
 }
 
 func (v *value) Set(s string) error {
+	location := v.spelling
+	if location == "" {
+		location = "--" + v.flagName
+	}
+
+	location = v.spelt(location)
+	return v.forward(location).set(s, location)
+}
+
+// The arguments being parsed by Parse, for each FlagSet.
+var parsingMu sync.Mutex
+var parsing = map[*flag.FlagSet][]string{}
+
+// Like fs.Parse, but allows the values of flags registered by AdaptToFlagSet
+// to find out whether each flag was given with one dash or two, so that
+// provenance records the flag as it was spelt. Calling flag.Parse is
+// sufficient for flag.CommandLine.
+func Parse(fs *flag.FlagSet, args []string) error {
+	parsingMu.Lock()
+	parsing[fs] = args
+	parsingMu.Unlock()
+
+	defer func() {
+		parsingMu.Lock()
+		delete(parsing, fs)
+		parsingMu.Unlock()
+	}()
+
+	return fs.Parse(args)
+}
+
+// Returns the flag given by location, e.g. "--bind", with the prefix used on
+// the command line, if known.
+func (v *value) spelt(location string) string {
+	if v.fs == nil {
+		return location
+	}
+
+	parsingMu.Lock()
+	args, ok := parsing[v.fs]
+	parsingMu.Unlock()
+	if !ok && v.fs == flag.CommandLine && len(os.Args) > 0 {
+		args, ok = os.Args[1:], true
+	}
+
+	// While parsing, fs.Args returns the arguments after the flag and its
+	// value, so the flag is one of the two arguments before them.
+	rest := v.fs.Args()
+	if !ok || len(rest) > len(args) {
+		return location
+	}
+
+	name := strings.TrimLeft(location, "-")
+	parsed := args[:len(args)-len(rest)]
+	for i := len(parsed) - 1; i >= 0 && i >= len(parsed)-2; i-- {
+		for _, prefix := range []string{"--", "-"} {
+			if parsed[i] == prefix+name || strings.HasPrefix(parsed[i], prefix+name+"=") {
+				return prefix + name
+			}
+		}
+	}
+
+	return location
+}
+
+// Returns a copy of fv which is registered with fs. See Parse.
+func bindFlagSet(fs *flag.FlagSet, fv Value) Value {
+	switch v := fv.(type) {
+	case *value:
+		nv := *v
+		nv.fs = fs
+		return &nv
+	case *negValue:
+		return &negValue{v: bindFlagSet(fs, v.v).(*value)}
+	default:
+		return fv
+	}
+}
+
+// Returns a Value for registering the flag described by info under another
+// name, such as its short name or an alias. The Value sets the same
+// configurable, but records the name it was registered under as the flag
+// used, so that provenance shows the flag as it was spelt on the command line.
+func NamedValue(info Info, name string) Value {
+	v, ok := info.Value.(*value)
+	if !ok {
+		return info.Value
+	}
+
+	nv := *v
+	nv.spelling = "--" + name
+	if len([]rune(name)) == 1 {
+		nv.spelling = "-" + name
+	}
+
+	return &nv
+}
+
+// Reports whether a and b are the same Value, or Values for the same
+// configurable returned by NamedValue.
+func sameValue(a, b interface{}) bool {
+	va, ok := a.(*value)
+	vb, ok2 := b.(*value)
+	if !ok || !ok2 {
+		return a == b
+	}
+
+	return va.c == vb.c
+}
+
+// Returns the value to which values given for v should be applied. This is v
+// itself, unless its configurable is deprecated in favour of another, in
+// which case it is a value for the replacement. Warns if the configurable is
+// deprecated.
+func (v *value) forward(location string) *value {
+	replacement, ok := deprecated(v.c)
+	if !ok {
+		return v
+	}
+
+	if replacement == "" {
+		provenance.Warnf("%s is deprecated", location)
+		return v
+	}
+
+	provenance.Warnf("%s is deprecated; use --%s instead", location, replacement)
+	c := manual.ByName(replacement)
+	if c == nil {
+		return v
+	}
+
+	return &value{c: c, flagName: replacement}
+}
+
+// Sets the value, recording the flag as spelt on the command line.
+func (v *value) set(x interface{}, location string) error {
 	cs, ok := v.c.(interface {
 		CfSetValue(v interface{}) error
 	})
@@ -82,30 +321,50 @@ func (v *value) Set(s string) error {
 		CfSetPriority(priority configurable.Priority)
 	})
 	if !ok {
-		return cs.CfSetValue(s)
+		err := cs.CfSetValue(x)
+		v.record(x, location, err == nil)
+		return err
 	}
 
 	if cp.CfGetPriority() <= configurable.FlagPriority {
-		err := cs.CfSetValue(s)
+		err := cs.CfSetValue(x)
 		if err != nil {
+			v.record(x, location, false)
 			return err
 		}
 
 		cp.CfSetPriority(configurable.FlagPriority)
+		v.record(x, location, true)
+	} else {
+		v.record(x, location, false)
 	}
 
 	return nil
 }
 
-func (v *value) Get() interface{} {
-	cg, ok := v.c.(interface {
-		CfValue() interface{}
+func (v *value) record(x interface{}, location string, applied bool) {
+	kind := provenance.Flag
+	if v.arg {
+		kind = provenance.Arg
+	}
+
+	provenance.Record(v.c, provenance.Source{
+		Kind:     kind,
+		Location: location,
+		Value:    x,
+		Applied:  applied,
 	})
-	if !ok {
+}
+
+func (v *value) Get() interface{} {
+	switch cg := v.c.(type) {
+	case interface{ CfValue() interface{} }:
+		return cg.CfValue()
+	case interface{ CfGetValue() interface{} }:
+		return cg.CfGetValue()
+	default:
 		return nil // ...
 	}
-
-	return cg.CfValue()
 }
 
 func (v *value) IsBoolFlag() bool {
@@ -113,9 +372,29 @@ func (v *value) IsBoolFlag() bool {
 	return ok
 }
 
-var adapted = map[interface{}]struct{}{}
+// The spf13/pflag package uses this to describe the value in help output.
+func (v *value) Type() string {
+	switch v.Get().(type) {
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case string:
+		return "string"
+	default:
+		return "value"
+	}
+}
 
-func adapt(path []string, c configurable.Configurable, f AdaptFunc) error {
+// The configurables which have been adapted to each target, so that adapting
+// to a target more than once only registers new configurables.
+var adaptedMu sync.Mutex
+var adaptedTo = map[interface{}]map[configurable.Configurable]struct{}{}
+
+// The target used by AdaptWithFunc.
+var funcTarget = new(int)
+
+func adapt(path []string, c configurable.Configurable, f func(info Info) error, adapted map[configurable.Configurable]struct{}) error {
 	_, ok := adapted[c]
 	if ok {
 		return nil
@@ -133,35 +412,74 @@ func adapt(path []string, c configurable.Configurable, f AdaptFunc) error {
 		return errNotSupported
 	}
 
-	v := &value{c: c}
+	v := &value{c: c, flagName: name, path: path}
+	if len(path) > 0 {
+		v.flagName = DottedPath(path) + "." + name
+	}
 	usage, _ := usageSummaryLine(c)
 
 	dfltv, ok := defaultValue(c)
 	dfltstr := ""
 	if ok {
 		dfltstr = fmt.Sprintf("%v", dfltv)
+		if provenance.IsSecret(c) && dfltstr != "" {
+			dfltstr = provenance.Redacted
+		}
+	}
+
+	short := shortName(c)
+	if short == 0 {
+		short = shortFlags[v.flagName]
 	}
 
-	f(Info{
+	// Even if registration fails, it is not retried.
+	adapted[c] = struct{}{}
+	return f(Info{
+		Configurable:       c,
 		Name:               name,
 		Usage:              usage,
 		Value:              v,
 		Path:               path,
 		DefaultValueString: dfltstr,
+		Short:              short,
+		Aliases:            aliases(c),
+		Hidden:             hidden(c),
 	})
-
-	adapted[c] = struct{}{}
-	return nil
 }
 
 // Gathered information about a configurable. This information makes it easy to
 // call flag.Var-like functions.
 type Info struct {
+	// The configurable being adapted.
+	Configurable configurable.Configurable
+
 	Name               string
 	Usage              string
 	Path               []string
 	Value              Value
 	DefaultValueString string
+
+	// The single-character short name of the flag, or 0 if none.
+	Short rune
+
+	// Alternative long names for the flag. These are not dotted paths, and
+	// should be registered as given.
+	Aliases []string
+
+	// Whether the flag should be omitted from help output. Deprecated flags
+	// are hidden.
+	Hidden bool
+}
+
+// Returns the name under which the flag should be registered, which is the
+// dotted path of the configurable, e.g. "server.bind".
+func (info *Info) FlagName() string {
+	dpn := DottedPath(info.Path)
+	if len(dpn) > 0 {
+		dpn += "."
+	}
+
+	return dpn + info.Name
 }
 
 // Called repeatedly by AdoptWithFunc. Your implementation of this function
@@ -170,8 +488,14 @@ type Info struct {
 // similar interfaces.
 type AdaptFunc func(info Info)
 
-func recursiveAdapt(path []string, c configurable.Configurable, f AdaptFunc) error {
-	adapt(path, c, f)
+// Returns the first error encountered, other than errNotSupported, but
+// continues to adapt the remaining configurables.
+func recursiveAdapt(path []string, c configurable.Configurable, f func(info Info) error, adapted map[configurable.Configurable]struct{}) error {
+	err := adapt(path, c, f, adapted)
+	if err == errNotSupported {
+		err = nil
+	}
+
 	cc, ok := c.(interface {
 		CfChildren() []configurable.Configurable
 	})
@@ -179,14 +503,52 @@ func recursiveAdapt(path []string, c configurable.Configurable, f AdaptFunc) err
 		n, ok := name(c)
 		if ok {
 			p := make([]string, 0, len(path)+1)
-			path = append(p, path...)
-			path = append(p, n)
+			path = append(append(p, path...), n)
 		}
 		for _, ch := range cc.CfChildren() {
-			recursiveAdapt(path, ch, f)
+			xerr := recursiveAdapt(path, ch, f, adapted)
+			if err == nil {
+				err = xerr
+			}
 		}
 	}
-	return nil
+	return err
+}
+
+// Registers c and its descendants using f, or if c is nil, all registered
+// configurables. f should return an error if a flag cannot be registered.
+//
+// target identifies the flag set being registered with, typically by a
+// pointer to it. Configurables which have already been adapted to target are
+// skipped, so it is safe to call this function multiple times. Returns the
+// first error returned by f; the remaining configurables are still adapted.
+//
+// Flag names are formed from the path from c downwards, so if c is a group
+// nested within another group, the outer group's name is not included.
+func AdaptTree(target interface{}, c configurable.Configurable, f func(info Info) error) error {
+	adaptedMu.Lock()
+	defer adaptedMu.Unlock()
+
+	adapted, ok := adaptedTo[target]
+	if !ok {
+		adapted = map[configurable.Configurable]struct{}{}
+		adaptedTo[target] = adapted
+	}
+
+	if c != nil {
+		return recursiveAdapt(nil, c, f, adapted)
+	}
+
+	var err error
+	configurable.Visit(func(c configurable.Configurable) error {
+		xerr := recursiveAdapt(nil, c, f, adapted)
+		if err == nil {
+			err = xerr
+		}
+		return nil
+	})
+
+	return err
 }
 
 // The interface which this package exposes to the flag packages it adapts to.
@@ -197,39 +559,110 @@ type Value interface {
 
 // Similar to Adapt, but allows you to register to the flag package of your
 // choice, so long as it implements an interface similar to the flag.Var
-// function.
+// function. Configurables which have already been adapted by a previous call
+// to AdaptWithFunc are skipped.
 func AdaptWithFunc(f AdaptFunc) {
-	configurable.Visit(func(c configurable.Configurable) error {
-		return recursiveAdapt(nil, c, f)
+	AdaptTree(funcTarget, nil, func(info Info) error {
+		f(info)
+		return nil
 	})
 }
 
-// Adapt registers all registered configurables as flags with the flag and
-// ogier/pflag packages. Note that Adapt will not do anything with
-// Configurables which it has already adapted once, thus it is safe to call
-// this function multiple times.
-func Adapt() {
-	AdaptWithFunc(func(info Info) {
-		dpn := DottedPath(info.Path)
-		if len(dpn) > 0 {
-			dpn += "."
+// Registers all registered configurables as flags with the given FlagSet.
+// Boolean configurables also get a --no-<name> counterpart; see Negation.
+// Short names and aliases are registered as additional flags setting the same
+// configurable; see NamedValue. Configurables which have already been adapted
+// to the FlagSet are skipped, so it is safe to call this function multiple
+// times. Returns an error if a flag of the same name is already defined; the
+// remaining configurables are still registered.
+func AdaptToFlagSet(fs *flag.FlagSet) error {
+	return AdaptTree(fs, nil, func(info Info) error {
+		name := info.FlagName()
+		if fs.Lookup(name) != nil {
+			return fmt.Errorf("flag already defined: %s", name)
+		}
+
+		fs.Var(bindFlagSet(fs, info.Value), name, info.Usage)
+		if neg, nv, ok := Negation(info); ok && fs.Lookup(neg) == nil {
+			fs.Var(bindFlagSet(fs, nv), neg, fmt.Sprintf("Negates --%s", name))
 		}
-		dpn += info.Name
-		flag.Var(info.Value, dpn, info.Usage)
-		pflag.Var(info.Value, dpn, info.Usage)
-		fl := kingpin.Flag(dpn, info.Usage)
-		if info.DefaultValueString != "" {
-			fl = fl.PlaceHolder(info.DefaultValueString)
-		} else {
-			fl = fl.PlaceHolder("\"\"")
+
+		var err error
+		if short := string(info.Short); info.Short != 0 {
+			if fs.Lookup(short) != nil {
+				err = fmt.Errorf("short flag -%s already defined (wanted by %s)", short, name)
+			} else {
+				fs.Var(bindFlagSet(fs, NamedValue(info, short)), short, info.Usage)
+			}
 		}
-		if r, ok := shortFlags[dpn]; ok {
-			fl = fl.Short(r)
+
+		for _, alias := range info.Aliases {
+			if fs.Lookup(alias) != nil {
+				if err == nil {
+					err = fmt.Errorf("flag already defined: %s (alias of %s)", alias, name)
+				}
+				continue
+			}
+
+			fs.Var(bindFlagSet(fs, NamedValue(info, alias)), alias, info.Usage)
 		}
-		fl.SetValue(info.Value)
+
+		return err
 	})
 }
 
+// Returns all the names under which the flag should be registered: its full
+// name, followed by its short name, if any, and aliases.
+func Names(info Info) []string {
+	names := []string{info.FlagName()}
+	if info.Short != 0 {
+		names = append(names, string(info.Short))
+	}
+
+	return append(names, info.Aliases...)
+}
+
+// Functions which adapt to the global flag sets of the flag packages other
+// than flag which are compiled in.
+var globalTargets []func() error
+
+// Registers all registered configurables as flags with the global flag sets
+// of the ogier/pflag and kingpin packages, except those omitted using build
+// tags. Returns the first error encountered; the remaining flag sets are
+// still registered with.
+func AdaptGlobalTargets() error {
+	var err error
+	for _, f := range globalTargets {
+		xerr := f()
+		if err == nil {
+			err = xerr
+		}
+	}
+
+	return err
+}
+
+// Adapt registers all registered configurables as flags with the global flag
+// sets of the flag, ogier/pflag and kingpin packages, except those omitted
+// using build tags. Note that Adapt will not do anything with Configurables
+// which it has already adapted once, thus it is safe to call this function
+// multiple times.
+//
+// Adapt panics if a flag cannot be registered, for example because a flag of
+// the same name or short name is already defined. Use AdaptToFlagSet and
+// AdaptGlobalTargets to handle such errors.
+func Adapt() {
+	err := AdaptToFlagSet(flag.CommandLine)
+	if err != nil {
+		panic(err)
+	}
+
+	err = AdaptGlobalTargets()
+	if err != nil {
+		panic(err)
+	}
+}
+
 func DottedPath(path []string) string {
 	return strings.Join(path, ".")
 }
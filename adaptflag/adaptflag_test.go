@@ -0,0 +1,331 @@
+package adaptflag
+
+import "fmt"
+import "flag"
+import "log"
+import "testing"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/cflag"
+import "gopkg.in/hlandau/easyconfig.v1/cstruct"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+func TestAdaptToFlagSet(t *testing.T) {
+	g := cflag.NewGroup(nil, "fstest")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := AdaptToFlagSet(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Adapting again only registers new configurables.
+	cflag.Int(g, "workers", 4, "Number of workers")
+	err = AdaptToFlagSet(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Parse([]string{"-fstest.bind=:8080", "-fstest.workers=8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bind.Value() != ":8080" {
+		t.Errorf("unexpected value: %#v", bind.Value())
+	}
+
+	// Other flag sets are unaffected.
+	if flag.Lookup("fstest.bind") != nil {
+		t.Error("flag registered with global flag set")
+	}
+
+	fs2 := flag.NewFlagSet("test2", flag.ContinueOnError)
+	fs2.String("fstest.bind", "", "Conflicting flag")
+	err = AdaptToFlagSet(fs2)
+	if err == nil {
+		t.Error("expected error for conflicting flag")
+	}
+	if fs2.Lookup("fstest.workers") == nil {
+		t.Error("expected remaining flags to be registered despite conflict")
+	}
+}
+
+func TestNegation(t *testing.T) {
+	g := cflag.NewGroup(&cflag.NoReg, "negtest")
+	verbose := cflag.Bool(g, "verbose", true, "Verbose output")
+	cflag.String(g, "bind", ":80", "Address to bind to")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := AdaptTree(fs, g, func(info Info) error {
+		fs.Var(info.Value, info.FlagName(), info.Usage)
+		if neg, nv, ok := Negation(info); ok {
+			fs.Var(nv, neg, "Negates --"+info.FlagName())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fs.Lookup("no-negtest.bind") != nil {
+		t.Error("unexpected negation of non-boolean flag")
+	}
+
+	err = fs.Parse([]string{"--no-negtest.verbose"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verbose.Value() {
+		t.Error("expected negation to set flag to false")
+	}
+
+	var b strings.Builder
+	fs.SetOutput(&b)
+	PrintDefaults(fs)
+	expected := `  --negtest.bind string
+        Address to bind to [default: ":80"] [config: negtest.bind]
+  --[no-]negtest.verbose
+        Verbose output [default: true] [config: negtest.verbose]
+`
+	if b.String() != expected {
+		t.Errorf("unexpected usage:\n%s", b.String())
+	}
+}
+
+func TestShortAndAliases(t *testing.T) {
+	g := cflag.NewGroup(nil, "aliastest")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to", cflag.Short('b'), cflag.Alias("listen"))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := AdaptToFlagSet(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"-b", ":1"}, {"--listen=:2"}, {"--aliastest.bind", ":3"}, {"-listen", ":4"}, {"-aliastest.bind=:5"}} {
+		err = Parse(fs, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bind.Value() != args[len(args)-1][len(args[len(args)-1])-2:] {
+			t.Errorf("unexpected value after %v: %#v", args, bind.Value())
+		}
+
+		// Provenance records the flag as spelt.
+		if src := provenance.Get(bind); src.Location != strings.SplitN(args[0], "=", 2)[0] {
+			t.Errorf("unexpected provenance after %v: %#v", args, src)
+		}
+	}
+
+	var b strings.Builder
+	fs.SetOutput(&b)
+	PrintDefaults(fs)
+	if !strings.Contains(b.String(), "  -b, --listen, --aliastest.bind string\n") {
+		t.Errorf("unexpected usage:\n%s", b.String())
+	}
+
+	cflag.Int(g, "bytes", 0, "Byte limit", cflag.Short('b'))
+	err = AdaptToFlagSet(fs)
+	if err == nil {
+		t.Error("expected error for duplicate short name")
+	}
+}
+
+func TestWriteUsage(t *testing.T) {
+	g := cflag.NewGroup(nil, "usagetest")
+	g.SetTitle("Usage Options")
+	cflag.String(g, "mode", "fast", "Mode of operation, which determines how quickly things are done", cflag.Enum("fast", "slow"))
+	cflag.Bool(g, "verbose", false, "Verbose output", cflag.Short('v'))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("version", false, "Print version")
+	err := AdaptTree(fs, g, func(info Info) error {
+		fs.Var(info.Value, info.FlagName(), info.Usage)
+		if info.Short != 0 {
+			fs.Var(info.Value, string(info.Short), info.Usage)
+		}
+		if neg, nv, ok := Negation(info); ok {
+			fs.Var(nv, neg, "Negates --"+info.FlagName())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Set("usagetest.mode", "medium")
+	if err == nil {
+		t.Error("expected error setting value not in enum")
+	}
+
+	UsageWidth = 60
+	defer func() { UsageWidth = 0 }()
+
+	var b strings.Builder
+	WriteUsage(&b, fs)
+	expected := `  --version
+        Print version
+
+Usage Options:
+  --usagetest.mode string
+        Mode of operation, which determines how quickly
+        things are done [default: "fast"] [config:
+        usagetest.mode] [values: fast, slow]
+  -v, --[no-]usagetest.verbose
+        Verbose output [config: usagetest.verbose]
+`
+	if b.String() != expected {
+		t.Errorf("unexpected usage:\n%s", b.String())
+	}
+}
+
+func TestNestedGroups(t *testing.T) {
+	outer := cflag.NewGroup(&cflag.NoReg, "outer")
+	inner := cflag.NewGroup(outer, "inner")
+	bind := cflag.String(inner, "bind", ":80", "Address to bind to")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := AdaptTree(fs, outer, func(info Info) error {
+		fs.Var(info.Value, info.FlagName(), info.Usage)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Parse([]string{"--outer.inner.bind=:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bind.Value() != ":8080" {
+		t.Errorf("unexpected value: %#v", bind.Value())
+	}
+
+	var b strings.Builder
+	WriteUsage(&b, fs)
+	if !strings.Contains(b.String(), "[config: outer.inner.bind]") {
+		t.Errorf("unexpected usage:\n%s", b.String())
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	g := cflag.NewGroup(nil, "deptest")
+	bind := cflag.String(g, "bind", ":80", "Address to bind to")
+	cflag.String(g, "listen", "", "Address to listen on", cflag.Deprecated("deptest.bind"))
+	cflag.Bool(g, "debug", false, "Debug mode", cflag.Hidden())
+
+	var warnings []string
+	provenance.Warnf = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	defer func() { provenance.Warnf = log.Printf }()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := AdaptTree(fs, g, func(info Info) error {
+		fs.Var(info.Value, info.FlagName(), info.Usage)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Parse([]string{"--deptest.listen=:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bind.Value() != ":8080" {
+		t.Errorf("value not forwarded to replacement: %#v", bind.Value())
+	}
+	if len(warnings) != 1 || warnings[0] != "--deptest.listen is deprecated; use --deptest.bind instead" {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	var b strings.Builder
+	WriteUsage(&b, fs)
+	if strings.Contains(b.String(), "listen") || strings.Contains(b.String(), "debug") {
+		t.Errorf("hidden flags shown in usage:\n%s", b.String())
+	}
+}
+
+func TestBindArgs(t *testing.T) {
+	var files struct {
+		Files []string `usage:"Files to copy" arg:"rest"`
+	}
+	g := cflag.NewGroup(nil, "argtest")
+	src := cflag.String(g, "src", "", "Source", cflag.Arg(1))
+	dst := cflag.String(g, "dst", ".", "Destination", cflag.Arg(2))
+	configurable.Register(cstruct.MustNew(&files, "argtest2"))
+
+	if s := Synopsis(); s != "<src> [<dst>] [<files>...]" {
+		t.Errorf("unexpected synopsis: %#v", s)
+	}
+
+	err := BindArgs(nil)
+	if err == nil || err.Error() != "missing argument: <src>" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = BindArgs([]string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Value() != "a" || dst.Value() != "." {
+		t.Errorf("unexpected values: %#v, %#v", src.Value(), dst.Value())
+	}
+
+	err = BindArgs([]string{"a", "b", "c", "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Value() != "b" || strings.Join(files.Files, ",") != "c,d" {
+		t.Errorf("unexpected values: %#v, %#v", dst.Value(), files.Files)
+	}
+}
+
+func TestWriteCompletion(t *testing.T) {
+	g := cflag.NewGroup(&cflag.NoReg, "comp")
+	cflag.String(g, "mode", "fast", "Mode of operation", cflag.Enum("fast", "slow"))
+	cflag.String(g, "log", "", "Log file", cflag.Path())
+	cflag.Bool(g, "verbose", false, "Verbose output")
+	cflag.Int(g, "debug", 0, "Debug level", cflag.Hidden())
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := AdaptTree(fs, g, func(info Info) error {
+		fs.Var(info.Value, info.FlagName(), info.Usage)
+		if neg, nv, ok := Negation(info); ok {
+			fs.Var(nv, neg, "Negates --"+info.FlagName())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"bash": {`'--comp.mode')`, `compgen -W 'fast slow'`, `'--comp.log')`, `--no-comp.verbose`, `complete -F _my_prog 'my-prog'`},
+		"zsh":  {`'--comp.mode=[Mode of operation]:value:(fast slow)'`, `'--comp.log=[Log file]:file:_files'`, `'--no-comp.verbose[Negates --comp.verbose]'`},
+		"fish": {`-l 'comp.mode' -d 'Mode of operation' -x -a 'fast slow'`, `-l 'comp.log' -d 'Log file' -r -F`, `-l 'comp.verbose' -d 'Verbose output'` + "\n"},
+	}
+	for shell, parts := range expected {
+		var b strings.Builder
+		err := WriteCompletion(&b, fs, shell, "my-prog")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, part := range parts {
+			if !strings.Contains(b.String(), part) {
+				t.Errorf("%s completion does not contain %s:\n%s", shell, part, b.String())
+			}
+		}
+		if strings.Contains(b.String(), "debug") {
+			t.Errorf("%s completion contains hidden flag:\n%s", shell, b.String())
+		}
+	}
+
+	if WriteCompletion(&strings.Builder{}, fs, "csh", "my-prog") == nil {
+		t.Error("expected error for unsupported shell")
+	}
+}
@@ -0,0 +1,192 @@
+package adaptflag
+
+import "fmt"
+import "reflect"
+import "sort"
+import "strconv"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+
+// Returns the position of the positional argument which sets the
+// configurable, e.g. "1" or "rest", or "" if none.
+func argPosition(c configurable.Configurable) string {
+	v, ok := c.(interface {
+		CfArg() string
+	})
+	if !ok {
+		return ""
+	}
+
+	return v.CfArg()
+}
+
+type argSpec struct {
+	c        configurable.Configurable
+	name     string
+	pos      int
+	required bool
+}
+
+func (a *argSpec) placeholder() string {
+	return "<" + a.name + ">"
+}
+
+// Returns the registered configurables which receive numbered positional
+// arguments, in order of position, and the configurable which receives the
+// remaining arguments, if any.
+func argSpecs() (args []argSpec, rest *argSpec, err error) {
+	var visit func(c configurable.Configurable) error
+	visit = func(c configurable.Configurable) error {
+		cc, ok := c.(interface {
+			CfChildren() []configurable.Configurable
+		})
+		if ok {
+			for _, ch := range cc.CfChildren() {
+				err := visit(ch)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		pos := argPosition(c)
+		if pos == "" {
+			return nil
+		}
+
+		n, _ := name(c)
+		spec := argSpec{c: c, name: n}
+		if pos == "rest" {
+			if rest != nil {
+				return fmt.Errorf("more than one configurable receives the remaining arguments: %s, %s", rest.name, n)
+			}
+			rest = &spec
+			return nil
+		}
+
+		var perr error
+		spec.pos, perr = strconv.Atoi(pos)
+		if perr != nil || spec.pos < 1 {
+			return fmt.Errorf("invalid argument position for configurable %s: %#v", n, pos)
+		}
+
+		dflt, ok := defaultValue(c)
+		spec.required = !ok || dflt == nil || reflect.ValueOf(dflt).IsZero()
+		args = append(args, spec)
+		return nil
+	}
+
+	err = configurable.Visit(visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(args, func(i, j int) bool {
+		return args[i].pos < args[j].pos
+	})
+
+	for i := range args {
+		switch {
+		case i > 0 && args[i].pos == args[i-1].pos:
+			return nil, nil, fmt.Errorf("more than one configurable receives argument %d: %s, %s", args[i].pos, args[i-1].name, args[i].name)
+		case args[i].pos != i+1:
+			return nil, nil, fmt.Errorf("no configurable receives argument %d", i+1)
+		case i > 0 && args[i].required && !args[i-1].required:
+			return nil, nil, fmt.Errorf("argument %d is required but follows an optional argument", i+1)
+		}
+	}
+
+	return args, rest, nil
+}
+
+// Sets the registered configurables which receive positional command line
+// arguments, such as those created with cflag.Arg or from cstruct fields with
+// an arg tag, from args, which is typically flag.Args(). Values are set with
+// the same priority as flags.
+//
+// Returns an error if a required argument is missing, or if there are more
+// arguments than configurables to receive them. If no registered configurable
+// receives positional arguments, BindArgs does nothing, leaving args to be
+// handled by the program.
+func BindArgs(args []string) error {
+	specs, rest, err := argSpecs()
+	if err != nil {
+		return err
+	}
+
+	if len(specs) == 0 && rest == nil {
+		return nil
+	}
+
+	for i := range specs {
+		if i >= len(args) {
+			if specs[i].required {
+				return fmt.Errorf("missing argument: %s", specs[i].placeholder())
+			}
+			continue
+		}
+
+		v := &value{c: specs[i].c, flagName: specs[i].name, arg: true}
+		err := v.set(args[i], strconv.Itoa(i+1))
+		if err != nil {
+			return fmt.Errorf("invalid argument %s: %v", specs[i].placeholder(), err)
+		}
+	}
+
+	if len(args) <= len(specs) {
+		return nil
+	}
+
+	if rest == nil {
+		return fmt.Errorf("unexpected argument: %q", args[len(specs)])
+	}
+
+	v := &value{c: rest.c, flagName: rest.name, arg: true}
+	err = v.set(args[len(specs):], strconv.Itoa(len(specs)+1))
+	if err != nil {
+		return fmt.Errorf("invalid argument %s: %v", rest.placeholder(), err)
+	}
+
+	return nil
+}
+
+// Returns a synopsis of the positional arguments received by registered
+// configurables, e.g. "<src> [<dst>] [<files>...]", with optional arguments
+// in brackets, or "" if there are none or they are declared inconsistently.
+func Synopsis() string {
+	specs, rest, err := argSpecs()
+	if err != nil {
+		return ""
+	}
+
+	var parts []string
+	for i := range specs {
+		if specs[i].required {
+			parts = append(parts, specs[i].placeholder())
+		} else {
+			parts = append(parts, "["+specs[i].placeholder()+"]")
+		}
+	}
+
+	if rest != nil {
+		parts = append(parts, "["+rest.placeholder()+"...]")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Returns the first line of help output, e.g. "Usage of prog:", or if
+// configurables receive positional arguments, "Usage: prog [options] <src>".
+func usageLine(name string) string {
+	syn := Synopsis()
+	switch {
+	case syn != "" && name != "":
+		return fmt.Sprintf("Usage: %s [options] %s\n", name, syn)
+	case syn != "":
+		return fmt.Sprintf("Usage: [options] %s\n", syn)
+	case name != "":
+		return fmt.Sprintf("Usage of %s:\n", name)
+	default:
+		return "Usage:\n"
+	}
+}
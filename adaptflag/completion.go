@@ -0,0 +1,209 @@
+package adaptflag
+
+import "fmt"
+import "flag"
+import "io"
+import "regexp"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+
+// Reports whether the configurable's value is a file path, as indicated by a
+// method CfPath() bool.
+func isPath(c configurable.Configurable) bool {
+	v, ok := c.(interface {
+		CfPath() bool
+	})
+
+	return ok && v.CfPath()
+}
+
+type completionFlag struct {
+	// The flag as spelt on the command line, e.g. "--server.bind" or "-b".
+	spelling string
+	usage    string
+
+	takesValue bool
+	enum       []string
+	path       bool
+}
+
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		if isHidden(f.Value) {
+			return
+		}
+
+		cf := completionFlag{
+			spelling:   "--" + f.Name,
+			usage:      strings.TrimSpace(strings.SplitN(f.Usage, "\n", 2)[0]),
+			takesValue: valuePlaceholder(f.Value) != "",
+		}
+		if len([]rune(f.Name)) == 1 {
+			cf.spelling = "-" + f.Name
+		}
+		if v, ok := f.Value.(*value); ok {
+			cf.enum = enumValues(v.c)
+			cf.path = isPath(v.c)
+		}
+
+		flags = append(flags, cf)
+	})
+
+	return flags
+}
+
+// Writes a script to w which provides tab completion of the flags of fs for
+// the given shell, which is "bash", "zsh" or "fish". program is the name of
+// the command being completed. Flags restricted to a set of values complete
+// those values, flags whose values are file paths complete file names, and
+// hidden flags are omitted.
+//
+// The script can be loaded with e.g. "source <(program --completion=bash)".
+func WriteCompletion(w io.Writer, fs *flag.FlagSet, shell, program string) error {
+	flags := completionFlags(fs)
+
+	var b strings.Builder
+	switch shell {
+	case "bash":
+		bashCompletion(&b, flags, program)
+	case "zsh":
+		zshCompletion(&b, flags, program)
+	case "fish":
+		fishCompletion(&b, flags, program)
+	default:
+		return fmt.Errorf("unsupported shell: %q", shell)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var reNonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Returns the name of the shell function which completes program.
+func completionFunc(program string) string {
+	return "_" + reNonIdentifier.ReplaceAllString(program, "_")
+}
+
+// Quotes s for bash or zsh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func bashCompletion(b *strings.Builder, flags []completionFlag, program string) {
+	fn := completionFunc(program)
+	fmt.Fprintf(b, "# bash completion for %s\n", program)
+	fmt.Fprintf(b, "%s() {\n", fn)
+	b.WriteString(`	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	if [[ "$cur" == "=" ]]; then
+		cur=""
+	elif [[ "$prev" == "=" ]]; then
+		prev="${COMP_WORDS[COMP_CWORD-2]}"
+	fi
+
+	case "$prev" in
+`)
+
+	var words, other []string
+	for _, f := range flags {
+		words = append(words, f.spelling)
+		switch {
+		case len(f.enum) > 0:
+			fmt.Fprintf(b, "\t%s)\n\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n\t\treturn\n\t\t;;\n",
+				shellQuote(f.spelling), shellQuote(strings.Join(f.enum, " ")))
+		case f.path:
+			fmt.Fprintf(b, "\t%s)\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\treturn\n\t\t;;\n",
+				shellQuote(f.spelling))
+		case f.takesValue:
+			other = append(other, shellQuote(f.spelling))
+		}
+	}
+
+	if len(other) > 0 {
+		fmt.Fprintf(b, "\t%s)\n\t\tCOMPREPLY=()\n\t\treturn\n\t\t;;\n", strings.Join(other, "|"))
+	}
+
+	fmt.Fprintf(b, `	esac
+
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W %s -- "$cur"))
+		return
+	fi
+
+	COMPREPLY=($(compgen -f -- "$cur"))
+}
+complete -F %s %s
+`, shellQuote(strings.Join(words, " ")), fn, shellQuote(program))
+}
+
+var zshSpecEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+
+func zshCompletion(b *strings.Builder, flags []completionFlag, program string) {
+	fn := completionFunc(program)
+	fmt.Fprintf(b, "#compdef %s\n\n", program)
+	fmt.Fprintf(b, "%s() {\n\t_arguments \\\n", fn)
+	for _, f := range flags {
+		spec := zshSpecEscaper.Replace(f.spelling)
+		if f.takesValue {
+			spec += "="
+		}
+		if f.usage != "" {
+			spec += "[" + zshSpecEscaper.Replace(f.usage) + "]"
+		}
+
+		switch {
+		case len(f.enum) > 0:
+			spec += ":value:(" + zshSpecEscaper.Replace(strings.Join(f.enum, " ")) + ")"
+		case f.path:
+			spec += ":file:_files"
+		case f.takesValue:
+			spec += ":value: "
+		}
+
+		fmt.Fprintf(b, "\t\t%s \\\n", shellQuote(spec))
+	}
+
+	fmt.Fprintf(b, `		'*:file:_files'
+}
+
+if [ "$funcstack[1]" = %s ]; then
+	%s "$@"
+else
+	compdef %s %s
+fi
+`, shellQuote(fn), fn, fn, shellQuote(program))
+}
+
+var fishEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// Quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + fishEscaper.Replace(s) + "'"
+}
+
+func fishCompletion(b *strings.Builder, flags []completionFlag, program string) {
+	fmt.Fprintf(b, "# fish completion for %s\n", program)
+	for _, f := range flags {
+		fmt.Fprintf(b, "complete -c %s", fishQuote(program))
+		if strings.HasPrefix(f.spelling, "--") {
+			fmt.Fprintf(b, " -l %s", fishQuote(f.spelling[2:]))
+		} else {
+			fmt.Fprintf(b, " -s %s", fishQuote(f.spelling[1:]))
+		}
+		if f.usage != "" {
+			fmt.Fprintf(b, " -d %s", fishQuote(f.usage))
+		}
+
+		switch {
+		case len(f.enum) > 0:
+			fmt.Fprintf(b, " -x -a %s", fishQuote(strings.Join(f.enum, " ")))
+		case f.path:
+			b.WriteString(" -r -F")
+		case f.takesValue:
+			b.WriteString(" -x")
+		}
+
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !noadaptkingpin
+// +build !noadaptkingpin
+
+package adaptflag
+
+import "fmt"
+import "gopkg.in/alecthomas/kingpin.v2"
+
+func init() {
+	globalTargets = append(globalTargets, func() error {
+		return AdaptToKingpin(kingpin.CommandLine)
+	})
+}
+
+// Registers all registered configurables as flags with the given kingpin
+// Application. kingpin itself provides --no-<name> counterparts for boolean
+// configurables. Aliases are registered as additional hidden flags. Configurables
+// which have already been adapted to the Application are skipped. Returns an
+// error if a flag of the same name is already defined; the remaining
+// configurables are still registered. Duplicate short names are also reported
+// as errors.
+func AdaptToKingpin(app *kingpin.Application) error {
+	return AdaptTree(app, nil, func(info Info) error {
+		name := info.FlagName()
+		if app.GetFlag(name) != nil {
+			return fmt.Errorf("flag already defined: %s", name)
+		}
+
+		var err error
+		short := info.Short
+		if short != 0 {
+			for _, fm := range app.Model().Flags {
+				if fm.Short == short {
+					err = fmt.Errorf("short flag -%c already defined (wanted by %s)", short, name)
+					short = 0
+					break
+				}
+			}
+		}
+
+		fl := app.Flag(name, info.Usage)
+		if info.DefaultValueString != "" {
+			fl = fl.PlaceHolder(info.DefaultValueString)
+		} else {
+			fl = fl.PlaceHolder("\"\"")
+		}
+		if short != 0 {
+			fl = fl.Short(short)
+		}
+		if info.Hidden {
+			fl = fl.Hidden()
+		}
+		fl.SetValue(info.Value)
+
+		for _, alias := range info.Aliases {
+			if app.GetFlag(alias) != nil {
+				if err == nil {
+					err = fmt.Errorf("flag already defined: %s (alias of %s)", alias, name)
+				}
+				continue
+			}
+
+			app.Flag(alias, info.Usage).Hidden().SetValue(NamedValue(info, alias))
+		}
+
+		return err
+	})
+}
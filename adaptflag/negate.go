@@ -0,0 +1,53 @@
+package adaptflag
+
+import "strconv"
+
+// The prefix of the names of flags which negate boolean flags.
+const negationPrefix = "no-"
+
+// Returns the name and Value of a flag which sets a boolean configurable to
+// false, e.g. "no-server.verbose" for "server.verbose". ok is false if the
+// configurable is not boolean. Flag packages which do not handle negation
+// themselves can register the returned Value alongside info.Value, taking care
+// not to replace a flag of the same name.
+func Negation(info Info) (name string, v Value, ok bool) {
+	val, ok := info.Value.(*value)
+	if !ok || !val.IsBoolFlag() {
+		return "", nil, false
+	}
+
+	return negationPrefix + info.FlagName(), &negValue{v: val}, true
+}
+
+// Reports whether fv is a Value returned by Negation.
+func IsNegation(fv interface{}) bool {
+	_, ok := fv.(*negValue)
+	return ok
+}
+
+type negValue struct {
+	v *value
+}
+
+func (nv *negValue) String() string {
+	return "false"
+}
+
+func (nv *negValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	location := nv.v.spelt("--" + negationPrefix + nv.v.flagName)
+	return nv.v.forward(location).set(strconv.FormatBool(!b), location)
+}
+
+func (nv *negValue) IsBoolFlag() bool {
+	return true
+}
+
+// The spf13/pflag package uses this to describe the value in help output.
+func (nv *negValue) Type() string {
+	return "bool"
+}
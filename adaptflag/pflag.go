@@ -0,0 +1,90 @@
+//go:build !noadaptpflag
+// +build !noadaptpflag
+
+package adaptflag
+
+import "fmt"
+import "io"
+import "os"
+import "github.com/ogier/pflag"
+
+func init() {
+	globalTargets = append(globalTargets, func() error {
+		return AdaptToPFlagSet(pflag.CommandLine)
+	})
+}
+
+// Registers all registered configurables as flags with the given ogier/pflag
+// FlagSet. Boolean configurables also get a --no-<name> counterpart; see
+// Negation. Aliases are registered as additional flags sharing the same Value.
+// Configurables which have already been adapted to the FlagSet are skipped.
+// Returns an error if a flag of the same name or short name is already
+// defined; the remaining configurables are still registered.
+func AdaptToPFlagSet(fs *pflag.FlagSet) error {
+	return AdaptTree(fs, nil, func(info Info) error {
+		name := info.FlagName()
+		if fs.Lookup(name) != nil {
+			return fmt.Errorf("flag already defined: %s", name)
+		}
+
+		var err error
+		short := ""
+		if info.Short != 0 {
+			short = string(info.Short)
+			if len(short) != 1 {
+				err = fmt.Errorf("short name of flag %s must be a single byte: %q", name, short)
+				short = ""
+			}
+
+			fs.VisitAll(func(f *pflag.Flag) {
+				if short != "" && f.Shorthand == short {
+					err = fmt.Errorf("short flag -%s already defined (wanted by %s)", short, name)
+					short = ""
+				}
+			})
+		}
+
+		fs.VarP(info.Value, name, short, info.Usage)
+		if neg, nv, ok := Negation(info); ok && fs.Lookup(neg) == nil {
+			fs.Var(nv, neg, fmt.Sprintf("Negates --%s", name))
+		}
+
+		for _, alias := range info.Aliases {
+			if fs.Lookup(alias) != nil {
+				if err == nil {
+					err = fmt.Errorf("flag already defined: %s (alias of %s)", alias, name)
+				}
+				continue
+			}
+
+			fs.Var(NamedValue(info, alias), alias, info.Usage)
+		}
+
+		return err
+	})
+}
+
+// Returns a function which writes help for the flags of fs to standard error,
+// suitable for assigning to fs.Usage or pflag.Usage. See WriteUsage.
+func PFlagUsage(fs *pflag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, usageLine(""))
+		WritePFlagUsage(os.Stderr, fs)
+	}
+}
+
+// Like WriteUsage, but for an ogier/pflag FlagSet.
+func WritePFlagUsage(w io.Writer, fs *pflag.FlagSet) {
+	var flags []usageFlag
+	fs.VisitAll(func(f *pflag.Flag) {
+		flags = append(flags, usageFlag{
+			name:     f.Name,
+			short:    f.Shorthand,
+			usage:    f.Usage,
+			defValue: f.DefValue,
+			value:    f.Value,
+		})
+	})
+
+	writeUsage(w, flags)
+}
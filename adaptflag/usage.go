@@ -0,0 +1,310 @@
+package adaptflag
+
+import "fmt"
+import "flag"
+import "io"
+import "os"
+import "strconv"
+import "strings"
+import "golang.org/x/term"
+import "gopkg.in/hlandau/configurable.v1"
+
+// Like flag.PrintDefaults, but for the given FlagSet. Equivalent to
+// WriteUsage(fs.Output(), fs).
+func PrintDefaults(fs *flag.FlagSet) {
+	WriteUsage(fs.Output(), fs)
+}
+
+// The width to which help output is wrapped. If 0, the COLUMNS environment
+// variable is used, or failing that, the width of the terminal on standard
+// error, or failing that, 80.
+var UsageWidth int
+
+// A flag as registered with a flag set, for rendering help.
+type usageFlag struct {
+	name     string
+	short    string
+	usage    string
+	defValue string
+	value    interface{}
+}
+
+// Returns a function which writes help for the flags of fs to its output,
+// suitable for assigning to fs.Usage or flag.Usage. See WriteUsage. If
+// configurables receive positional arguments, the help begins with a synopsis
+// of them; see Synopsis.
+func Usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(fs.Output(), usageLine(fs.Name()))
+		WriteUsage(fs.Output(), fs)
+	}
+}
+
+// Writes help for the flags of fs to w. Flags for configurables are listed
+// under headings naming the groups which contain them, or giving the groups'
+// titles if set (see cflag.Group.SetTitle), in the order in which the
+// configurables were registered, with the flag's short name, aliases and
+// negation, if any. Usage text is wrapped to UsageWidth and followed by the
+// default value, environment variable, configuration file key and allowed
+// values. Other flags are listed first. Hidden flags are omitted.
+func WriteUsage(w io.Writer, fs *flag.FlagSet) {
+	var flags []usageFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, usageFlag{
+			name:     f.Name,
+			usage:    f.Usage,
+			defValue: f.DefValue,
+			value:    f.Value,
+		})
+	})
+
+	writeUsage(w, flags)
+}
+
+type usageSection struct {
+	heading string
+	entries []string
+}
+
+func writeUsage(w io.Writer, flags []usageFlag) {
+	byName := map[string]*usageFlag{}
+	for i := range flags {
+		byName[flags[i].name] = &flags[i]
+	}
+
+	// Flags which have been shown, including aliases and negations. Hidden
+	// flags are treated as already shown.
+	shown := map[string]bool{}
+	for _, f := range flags {
+		if isHidden(f.value) {
+			shown[f.name] = true
+		}
+	}
+
+	var sections []*usageSection
+	sectionsByHeading := map[string]*usageSection{}
+	section := func(heading string) *usageSection {
+		s, ok := sectionsByHeading[heading]
+		if !ok {
+			s = &usageSection{heading: heading}
+			sectionsByHeading[heading] = s
+			sections = append(sections, s)
+		}
+		return s
+	}
+
+	// Other flags are shown first.
+	others := section("")
+
+	var visit func(c configurable.Configurable, title string)
+	visit = func(c configurable.Configurable, title string) {
+		if cc, ok := c.(interface {
+			CfChildren() []configurable.Configurable
+		}); ok {
+			if t := groupTitle(c); t != "" {
+				title = t
+			} else if _, ok := name(c); ok {
+				title = ""
+			}
+			for _, ch := range cc.CfChildren() {
+				visit(ch, title)
+			}
+			return
+		}
+
+		for _, f := range flags {
+			v, ok := f.value.(*value)
+			if !ok || v.c != c || f.name != v.flagName || shown[f.name] {
+				continue
+			}
+
+			heading := title
+			if heading == "" {
+				heading = DottedPath(v.path)
+			}
+
+			s := section(heading)
+			s.entries = append(s.entries, usageEntry(&f, flags, byName, shown))
+		}
+	}
+	configurable.Visit(func(c configurable.Configurable) error {
+		visit(c, "")
+		return nil
+	})
+
+	for i := range flags {
+		if !shown[flags[i].name] {
+			others.entries = append(others.entries, usageEntry(&flags[i], flags, byName, shown))
+		}
+	}
+
+	first := true
+	for _, s := range sections {
+		if len(s.entries) == 0 {
+			continue
+		}
+
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+
+		if s.heading != "" {
+			fmt.Fprintf(w, "%s:\n", s.heading)
+		}
+		for _, e := range s.entries {
+			io.WriteString(w, e)
+		}
+	}
+}
+
+func usageEntry(f *usageFlag, flags []usageFlag, byName map[string]*usageFlag, shown map[string]bool) string {
+	shown[f.name] = true
+
+	var shorts, longs []string
+	if f.short != "" {
+		shorts = append(shorts, "-"+f.short)
+	}
+
+	if _, ok := f.value.(*value); ok {
+		for _, af := range flags {
+			if !sameValue(af.value, f.value) || af.name == f.name {
+				continue
+			}
+
+			shown[af.name] = true
+			if len(af.name) == 1 {
+				shorts = append(shorts, "-"+af.name)
+			} else {
+				longs = append(longs, "--"+af.name)
+			}
+		}
+	}
+
+	name := f.name
+	if neg, ok := byName[negationPrefix+f.name]; ok && IsNegation(neg.value) {
+		shown[neg.name] = true
+		name = "[" + negationPrefix + "]" + name
+	}
+
+	var b strings.Builder
+	b.WriteString("  ")
+	b.WriteString(strings.Join(append(append(shorts, longs...), "--"+name), ", "))
+	if placeholder := valuePlaceholder(f.value); placeholder != "" {
+		b.WriteString(" ")
+		b.WriteString(placeholder)
+	}
+	b.WriteString("\n")
+
+	text := f.usage
+	if f.defValue != "" && f.defValue != "false" && f.defValue != "0" && f.defValue != `""` {
+		text += fmt.Sprintf(" [default: %s]", f.defValue)
+	}
+
+	if v, ok := f.value.(*value); ok {
+		if env := envVarName(v.c); env != "" {
+			text += fmt.Sprintf(" [env: %s]", env)
+		}
+
+		text += fmt.Sprintf(" [config: %s]", v.flagName)
+
+		if values := enumValues(v.c); len(values) > 0 {
+			text += fmt.Sprintf(" [values: %s]", strings.Join(values, ", "))
+		}
+	}
+
+	for _, line := range wrap(strings.TrimSpace(text), usageWidth()-usageIndent) {
+		b.WriteString(strings.Repeat(" ", usageIndent))
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+const usageIndent = 8
+
+// Returns the title of a group, or "" if it has none.
+func groupTitle(c configurable.Configurable) string {
+	v, ok := c.(interface {
+		CfTitle() string
+	})
+	if !ok {
+		return ""
+	}
+
+	return v.CfTitle()
+}
+
+// Reports whether fv is a Value for a hidden configurable, or its negation.
+// Other flag values can be hidden by implementing CfHidden() bool.
+func isHidden(fv interface{}) bool {
+	switch v := fv.(type) {
+	case *value:
+		return hidden(v.c)
+	case *negValue:
+		return hidden(v.v.c)
+	default:
+		h, ok := fv.(interface {
+			CfHidden() bool
+		})
+		return ok && h.CfHidden()
+	}
+}
+
+func valuePlaceholder(fv interface{}) string {
+	if vb, ok := fv.(interface {
+		IsBoolFlag() bool
+	}); ok && vb.IsBoolFlag() {
+		return ""
+	}
+
+	if v, ok := fv.(*value); ok {
+		return v.Type()
+	}
+
+	return "value"
+}
+
+func usageWidth() int {
+	if UsageWidth > 0 {
+		return UsageWidth
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+
+	if n, _, err := term.GetSize(int(os.Stderr.Fd())); err == nil && n > 0 {
+		return n
+	}
+
+	return 80
+}
+
+// Wraps text to the given width, breaking at spaces. Existing line breaks are
+// preserved. Words longer than the width are not broken.
+func wrap(text string, width int) []string {
+	if width < 20 {
+		width = 20
+	}
+
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			switch {
+			case line == "":
+				line = word
+			case len(line)+1+len(word) > width:
+				lines = append(lines, line)
+				line = word
+			default:
+				line += " " + word
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
@@ -5,13 +5,18 @@
 // becomes a child of the configurable passed, or nil, in which case
 // the configurable is registered at the top level.
 //
-// You should call Value() to get the value of a flag configurable.
+// You should call Value() to get the value of a flag configurable. Value() and
+// SetValue() may be called concurrently with configuration being reloaded.
+// For flags created with the *Var functions, the variable passed may also be
+// read directly, but such reads are not safe if configuration may be reloaded
+// concurrently.
 package cflag
 
 import "fmt"
 import "strconv"
 import "regexp"
 import "strings"
+import "sync"
 import "gopkg.in/hlandau/configurable.v1"
 
 // Group
@@ -42,6 +47,7 @@ func register(r Registerable, c configurable.Configurable) {
 type Group struct {
 	configurables []configurable.Configurable
 	name          string
+	title         string
 }
 
 func (ig *Group) CfName() string {
@@ -52,6 +58,18 @@ func (ig *Group) CfChildren() []configurable.Configurable {
 	return ig.configurables
 }
 
+// Returns the title set with SetTitle, or "" if none has been set.
+func (ig *Group) CfTitle() string {
+	return ig.title
+}
+
+// Sets a title for the group, such as "Server Options", to be used as its
+// heading in help output instead of its name. Unlike the name, the title
+// does not form part of the names of flags in the group.
+func (ig *Group) SetTitle(title string) {
+	ig.title = title
+}
+
 func (ig *Group) String() string {
 	return fmt.Sprintf("%s", ig.name)
 }
@@ -71,6 +89,181 @@ func NewGroup(reg Registerable, name string) *Group {
 	return ig
 }
 
+// Options
+
+// Optional metadata which can be passed to the functions which create flags.
+type Option func(m *meta)
+
+// Metadata common to all flag types.
+type meta struct {
+	restart bool
+	secret  bool
+	short   rune
+	aliases []string
+	enum    []string
+
+	hidden      bool
+	deprecated  bool
+	replacement string
+
+	arg  string
+	path bool
+}
+
+func (m *meta) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(m)
+	}
+}
+
+// Reports whether changes to the flag only take effect after a restart. Such
+// flags are left unchanged when configuration is reloaded.
+func (m *meta) CfRestartRequired() bool {
+	return m.restart
+}
+
+// Reports whether the flag's value is secret, such as a password, and should
+// be redacted wherever values are shown.
+func (m *meta) CfSecret() bool {
+	return m.secret
+}
+
+// Returns the flag's single-character short name, or 0 if it has none.
+func (m *meta) CfShortName() rune {
+	return m.short
+}
+
+// Returns alternative long names for the flag.
+func (m *meta) CfAliases() []string {
+	return m.aliases
+}
+
+// Returns the values the flag may take, or nil if it may take any value.
+func (m *meta) CfEnumValues() []string {
+	return m.enum
+}
+
+// Reports whether the flag should be omitted from help output.
+func (m *meta) CfHidden() bool {
+	return m.hidden
+}
+
+// Reports whether the flag is deprecated, and if so, the dotted path of the
+// configurable which replaces it, if any.
+func (m *meta) CfDeprecated() (replacement string, deprecated bool) {
+	return m.replacement, m.deprecated
+}
+
+// Returns the position of the positional command line argument which sets
+// the flag, e.g. "1", or "" if none.
+func (m *meta) CfArg() string {
+	return m.arg
+}
+
+// Reports whether the flag's value is a file path, so that shell completion
+// can offer file names.
+func (m *meta) CfPath() bool {
+	return m.path
+}
+
+// Returns an error if the flag has a set of allowed values which does not
+// include s.
+func (m *meta) checkEnum(name, s string) error {
+	if len(m.enum) == 0 {
+		return nil
+	}
+
+	for _, e := range m.enum {
+		if e == s {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid value for configurable %#v, expecting one of: %s", name, strings.Join(m.enum, ", "))
+}
+
+// Marks a flag as requiring a restart for changes to take effect, for example
+// because it is only consulted at startup. When configuration is reloaded, the
+// flag is left unchanged and reported as requiring a restart if its
+// configured value has changed.
+func Restart() Option {
+	return func(m *meta) {
+		m.restart = true
+	}
+}
+
+// Marks a flag as safe to change while the program is running. This is the
+// default.
+func Live() Option {
+	return func(m *meta) {
+		m.restart = false
+	}
+}
+
+// Gives a flag a single-character short name, e.g. 'b' for -b, which flag
+// packages accept in addition to its full name.
+func Short(r rune) Option {
+	return func(m *meta) {
+		m.short = r
+	}
+}
+
+// Restricts a string flag to the given values. Attempts to set any other
+// value fail, and the allowed values are shown in help output.
+func Enum(values ...string) Option {
+	return func(m *meta) {
+		m.enum = append(m.enum, values...)
+	}
+}
+
+// Omits a flag from help output. The flag is still accepted.
+func Hidden() Option {
+	return func(m *meta) {
+		m.hidden = true
+	}
+}
+
+// Marks a flag as deprecated, for example because it has been renamed. The
+// flag is omitted from help output, but is still accepted from the command
+// line, environment variables and configuration files, with a warning.
+//
+// If replacement is not empty, it is the dotted path of the configurable
+// which replaces the flag, e.g. "server.bind", and values given for the flag
+// are applied to the replacement instead.
+func Deprecated(replacement string) Option {
+	return func(m *meta) {
+		m.hidden = true
+		m.deprecated = true
+		m.replacement = replacement
+	}
+}
+
+// Gives a flag alternative long names, which flag packages accept in addition
+// to its full name. Unlike the full name, aliases are not prefixed with the
+// names of the groups containing the flag.
+func Alias(names ...string) Option {
+	return func(m *meta) {
+		m.aliases = append(m.aliases, names...)
+	}
+}
+
+// Sets a flag from the n-th positional command line argument, counting from
+// 1, in addition to its named flag. The argument is required unless the flag
+// has a non-empty default value. See adaptflag.BindArgs.
+func Arg(n int) Option {
+	return func(m *meta) {
+		m.arg = strconv.Itoa(n)
+	}
+}
+
+// Marks a flag's value as a file path, so that shell completion offers file
+// names.
+func Path() Option {
+	return func(m *meta) {
+		m.path = true
+	}
+}
+
 // String
 
 type StringFlag struct {
@@ -78,10 +271,16 @@ type StringFlag struct {
 	curValuep                                 *string
 	priority                                  configurable.Priority
 	onChange                                  []func(*StringFlag)
+	mu                                        sync.RWMutex
+	meta
 }
 
 func (sf *StringFlag) String() string {
-	return fmt.Sprintf("SimpleFlag(%s: %#v)", sf.name, *sf.curValuep)
+	if sf.secret {
+		return fmt.Sprintf("SimpleFlag(%s: [redacted])", sf.name)
+	}
+
+	return fmt.Sprintf("SimpleFlag(%s: %#v)", sf.name, sf.Value())
 }
 
 func (sf *StringFlag) CfSetValue(v interface{}) error {
@@ -92,7 +291,12 @@ func (sf *StringFlag) CfSetValue(v interface{}) error {
 		return fmt.Errorf("value must be a string")
 	}
 
-	*sf.curValuep = vs
+	err := sf.checkEnum(sf.name, vs)
+	if err != nil {
+		return err
+	}
+
+	sf.SetValue(vs)
 	return nil
 }
 
@@ -103,7 +307,7 @@ func (sf *StringFlag) notify() {
 }
 
 func (sf *StringFlag) CfValue() interface{} {
-	return *sf.curValuep
+	return sf.Value()
 }
 
 func (sf *StringFlag) CfName() string {
@@ -120,11 +324,17 @@ func (sf *StringFlag) CfDefaultValue() interface{} {
 
 // Get the flag's current value.
 func (sf *StringFlag) Value() string {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+
 	return *sf.curValuep
 }
 
 // Set the flag's current value.
 func (sf *StringFlag) SetValue(value string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
 	*sf.curValuep = value
 }
 
@@ -142,7 +352,7 @@ func (sf *StringFlag) CfGetPriority() configurable.Priority {
 
 // Creates a flag of type string. The variable referenced by pointer v is used as
 // the storage location for the value of the configurable.
-func StringVar(reg Registerable, v *string, name, defaultValue, summaryLine string) *StringFlag {
+func StringVar(reg Registerable, v *string, name, defaultValue, summaryLine string, opts ...Option) *StringFlag {
 	sf := &StringFlag{
 		name:         name,
 		summaryLine:  summaryLine,
@@ -154,6 +364,7 @@ func StringVar(reg Registerable, v *string, name, defaultValue, summaryLine stri
 		sf.curValuep = &sf.curValue
 	}
 
+	sf.apply(opts)
 	register(reg, sf)
 	return sf
 }
@@ -163,8 +374,28 @@ func StringVar(reg Registerable, v *string, name, defaultValue, summaryLine stri
 // reg: See package-level documentation.
 //
 // summaryLine: One-line usage summary.
-func String(reg Registerable, name, defaultValue, summaryLine string) *StringFlag {
-	return StringVar(reg, nil, name, defaultValue, summaryLine)
+//
+// opts: Optional metadata; see Option.
+func String(reg Registerable, name, defaultValue, summaryLine string, opts ...Option) *StringFlag {
+	return StringVar(reg, nil, name, defaultValue, summaryLine, opts...)
+}
+
+// Creates a flag of type string whose value is secret, such as a password.
+// The value can be read as usual, but is redacted by String and wherever
+// easyconfig shows values, such as in help output, configuration dumps and
+// provenance information.
+//
+// reg: See package-level documentation.
+//
+// summaryLine: One-line usage summary.
+//
+// opts: Optional metadata; see Option.
+func Secret(reg Registerable, name, defaultValue, summaryLine string, opts ...Option) *StringFlag {
+	opts = append(opts[0:len(opts):len(opts)], func(m *meta) {
+		m.secret = true
+	})
+
+	return StringVar(reg, nil, name, defaultValue, summaryLine, opts...)
 }
 
 // Int
@@ -175,10 +406,12 @@ type IntFlag struct {
 	curValuep              *int
 	priority               configurable.Priority
 	onChange               []func(*IntFlag)
+	mu                     sync.RWMutex
+	meta
 }
 
 func (sf *IntFlag) String() string {
-	return fmt.Sprintf("IntFlag(%s: %#v)", sf.name, *sf.curValuep)
+	return fmt.Sprintf("IntFlag(%s: %#v)", sf.name, sf.Value())
 }
 
 func (sf *IntFlag) CfSetValue(v interface{}) error {
@@ -186,7 +419,7 @@ func (sf *IntFlag) CfSetValue(v interface{}) error {
 
 	vi, ok := v.(int)
 	if ok {
-		*sf.curValuep = vi
+		sf.SetValue(vi)
 		return nil
 	}
 
@@ -198,7 +431,7 @@ func (sf *IntFlag) CfSetValue(v interface{}) error {
 			return err
 		}
 
-		*sf.curValuep = int(n)
+		sf.SetValue(int(n))
 		return nil
 	}
 
@@ -212,7 +445,7 @@ func (sf *IntFlag) notify() {
 }
 
 func (sf *IntFlag) CfValue() interface{} {
-	return sf.curValue
+	return sf.Value()
 }
 
 func (sf *IntFlag) CfName() string {
@@ -229,11 +462,17 @@ func (sf *IntFlag) CfDefaultValue() interface{} {
 
 // Get the flag's current value.
 func (sf *IntFlag) Value() int {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+
 	return *sf.curValuep
 }
 
 // Set the flag's current value.
 func (sf *IntFlag) SetValue(value int) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
 	*sf.curValuep = value
 }
 
@@ -251,7 +490,7 @@ func (sf *IntFlag) CfGetPriority() configurable.Priority {
 
 // Creates a flag of type int. The variable referenced by pointer v is used as
 // the storage location for the value of the configurable.
-func IntVar(reg Registerable, v *int, name string, defaultValue int, summaryLine string) *IntFlag {
+func IntVar(reg Registerable, v *int, name string, defaultValue int, summaryLine string, opts ...Option) *IntFlag {
 	sf := &IntFlag{
 		name:         name,
 		summaryLine:  summaryLine,
@@ -263,6 +502,7 @@ func IntVar(reg Registerable, v *int, name string, defaultValue int, summaryLine
 		sf.curValuep = &sf.curValue
 	}
 
+	sf.apply(opts)
 	register(reg, sf)
 	return sf
 }
@@ -272,8 +512,10 @@ func IntVar(reg Registerable, v *int, name string, defaultValue int, summaryLine
 // reg: See package-level documentation.
 //
 // summaryLine: One-line usage summary.
-func Int(reg Registerable, name string, defaultValue int, summaryLine string) *IntFlag {
-	return IntVar(reg, nil, name, defaultValue, summaryLine)
+//
+// opts: Optional metadata; see Option.
+func Int(reg Registerable, name string, defaultValue int, summaryLine string, opts ...Option) *IntFlag {
+	return IntVar(reg, nil, name, defaultValue, summaryLine, opts...)
 }
 
 // Bool
@@ -284,10 +526,12 @@ type BoolFlag struct {
 	curValuep              *bool
 	priority               configurable.Priority
 	onChange               []func(*BoolFlag)
+	mu                     sync.RWMutex
+	meta
 }
 
 func (sf *BoolFlag) String() string {
-	return fmt.Sprintf("BoolFlag(%s: %#v)", sf.name, sf.curValue)
+	return fmt.Sprintf("BoolFlag(%s: %#v)", sf.name, sf.Value())
 }
 
 var re_no = regexp.MustCompilePOSIX(`^(00?|no?|f(alse)?)$`)
@@ -297,20 +541,20 @@ func (sf *BoolFlag) CfSetValue(v interface{}) error {
 
 	vb, ok := v.(bool)
 	if ok {
-		*sf.curValuep = vb
+		sf.SetValue(vb)
 		return nil
 	}
 
 	vi, ok := v.(int)
 	if ok {
-		*sf.curValuep = (vi != 0)
+		sf.SetValue(vi != 0)
 		return nil
 	}
 
 	vs, ok := v.(string)
 	if ok {
 		vs = strings.TrimSpace(vs)
-		*sf.curValuep = !re_no.MatchString(vs)
+		sf.SetValue(!re_no.MatchString(vs))
 		return nil
 	}
 
@@ -324,7 +568,7 @@ func (sf *BoolFlag) notify() {
 }
 
 func (sf *BoolFlag) CfValue() interface{} {
-	return sf.curValue
+	return sf.Value()
 }
 
 func (sf *BoolFlag) CfName() string {
@@ -341,11 +585,17 @@ func (sf *BoolFlag) CfDefaultValue() interface{} {
 
 // Call to get the flag's current value.
 func (sf *BoolFlag) Value() bool {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+
 	return *sf.curValuep
 }
 
 // Set the flag's current value.
 func (sf *BoolFlag) SetValue(value bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
 	*sf.curValuep = value
 }
 
@@ -366,13 +616,15 @@ func (sf *BoolFlag) CfGetPriority() configurable.Priority {
 // reg: See package-level documentation.
 //
 // summaryLine: One-line usage summary.
-func Bool(reg Registerable, name string, defaultValue bool, summaryLine string) *BoolFlag {
-	return BoolVar(reg, nil, name, defaultValue, summaryLine)
+//
+// opts: Optional metadata; see Option.
+func Bool(reg Registerable, name string, defaultValue bool, summaryLine string, opts ...Option) *BoolFlag {
+	return BoolVar(reg, nil, name, defaultValue, summaryLine, opts...)
 }
 
 // Creates a flag of type bool. The variable referenced by pointer v is used as
 // the storage location for the value of the configurable.
-func BoolVar(reg Registerable, v *bool, name string, defaultValue bool, summaryLine string) *BoolFlag {
+func BoolVar(reg Registerable, v *bool, name string, defaultValue bool, summaryLine string, opts ...Option) *BoolFlag {
 	sf := &BoolFlag{
 		name:         name,
 		summaryLine:  summaryLine,
@@ -384,6 +636,7 @@ func BoolVar(reg Registerable, v *bool, name string, defaultValue bool, summaryL
 		sf.curValuep = &sf.curValue
 	}
 
+	sf.apply(opts)
 	register(reg, sf)
 	return sf
 }
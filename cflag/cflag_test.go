@@ -22,3 +22,15 @@ func Example() {
 	fmt.Printf("Bar:  %d\n", barFlag.Value())
 	fmt.Printf("Do Stuff: %v\n", doStuffFlag.Value())
 }
+
+func ExampleSecret() {
+	password := cflag.Secret(&cflag.NoReg, "password", "", "Password")
+	password.SetValue("hunter2")
+
+	fmt.Println(password)
+	fmt.Println(password.Value())
+
+	// Output:
+	// SimpleFlag(password: [redacted])
+	// hunter2
+}
@@ -4,21 +4,49 @@
 // To use cstruct, you call New or MustNew, passing a pointer to an instance of
 // an annotated structure type.
 //
-// The supported field types are string, int and bool. A field is only used if
-// it is public and has the `default` or `usage` tags specified on it, or both.
-// The name of the field will be used as the configurable name.
+// The supported field types are string, int and bool. Numeric values, such as
+// those decoded from configuration files, may also be assigned to fields of
+// other numeric types, so long as they can be represented exactly. A field is
+// only used if it is public and has the `default` or `usage` tags specified on
+// it, or both. The name of the field will be used as the configurable name.
 //
 // The following tags can be placed on fields:
 //
 //   default: The default value as a string.
 //   usage: A one-line usage summary.
+//   env: The name of an environment variable from which the value may be set.
+//   short: A single-character short name for the flag, e.g. "b" for -b.
+//   alias: Comma-separated alternative long names for the flag.
+//   enum: Comma-separated values which a string field may take. Attempts to
+//         set any other value fail.
+//   hidden: "true" if the field should be omitted from help output.
+//   deprecated: The dotted path of the configurable which replaces the field,
+//           e.g. "server.bind", or "true" if there is no replacement. The
+//           field is omitted from help output, and values given for it are
+//           applied to the replacement, with a warning.
+//   arg: The position of the positional command line argument which sets the
+//        field, counting from 1, or "rest" for a slice field which receives
+//        all remaining arguments. Numbered arguments are required unless the
+//        field has a non-empty default value.
+//   path: "true" if the value is a file path, so that shell completion
+//         offers file names.
+//   secret: "true" if the value is secret, such as a password, and should be
+//           redacted wherever easyconfig shows values.
+//   reload: "live" (the default) if the field may be changed while the program
+//           is running, or "restart" if changes only take effect after a
+//           restart. Fields marked "restart" are left unchanged when
+//           configuration is reloaded.
 //
 // Once you have created a cstruct Configurable group, you must register it
 // appropriately as you see fit, for example by calling configurable.Register.
+//
+// If the structure will be read by other goroutines while configuration may be
+// reloaded, use a Snapshot instead of New.
 package cstruct
 
 import "time"
 import "fmt"
+import "math"
 import "reflect"
 import "strings"
 import "regexp"
@@ -43,6 +71,15 @@ type value struct {
 	v                                  reflect.Value
 	defaultValue                       interface{}
 	priority                           configurable.Priority
+	restart                            bool
+	secret                             bool
+	short                              rune
+	aliases                            []string
+	enum                               []string
+	hidden, deprecated                 bool
+	replacement                        string
+	arg                                string
+	path                               bool
 }
 
 func (v *value) CfName() string {
@@ -69,6 +106,42 @@ func (v *value) CfEnvVarName() string {
 	return v.envVarName
 }
 
+func (v *value) CfRestartRequired() bool {
+	return v.restart
+}
+
+func (v *value) CfShortName() rune {
+	return v.short
+}
+
+func (v *value) CfAliases() []string {
+	return v.aliases
+}
+
+func (v *value) CfEnumValues() []string {
+	return v.enum
+}
+
+func (v *value) CfHidden() bool {
+	return v.hidden
+}
+
+func (v *value) CfDeprecated() (replacement string, deprecated bool) {
+	return v.replacement, v.deprecated
+}
+
+func (v *value) CfArg() string {
+	return v.arg
+}
+
+func (v *value) CfPath() bool {
+	return v.path
+}
+
+func (v *value) CfSecret() bool {
+	return v.secret
+}
+
 func (v *value) CfGetPriority() configurable.Priority {
 	return v.priority
 }
@@ -131,6 +204,57 @@ func New(target interface{}, name string) (c configurable.Configurable, err erro
 			name:             name,
 			envVarName:       envVarName,
 			usageSummaryLine: usage,
+			secret:           field.Tag.Get("secret") == "true",
+			path:             field.Tag.Get("path") == "true",
+		}
+
+		if short := []rune(field.Tag.Get("short")); len(short) == 1 {
+			vv.short = short[0]
+		} else if len(short) > 1 {
+			err = fmt.Errorf("invalid short tag on field %s: %#v", field.Name, string(short))
+			return
+		}
+
+		if alias := field.Tag.Get("alias"); alias != "" {
+			vv.aliases = strings.Split(alias, ",")
+		}
+
+		if enum := field.Tag.Get("enum"); enum != "" {
+			vv.enum = strings.Split(enum, ",")
+		}
+
+		vv.hidden = field.Tag.Get("hidden") == "true"
+		if deprecated := field.Tag.Get("deprecated"); deprecated != "" {
+			vv.hidden = true
+			vv.deprecated = true
+			if deprecated != "true" {
+				vv.replacement = deprecated
+			}
+		}
+
+		switch arg := field.Tag.Get("arg"); {
+		case arg == "":
+		case arg == "rest":
+			if vf.Kind() != reflect.Slice {
+				err = fmt.Errorf("arg tag \"rest\" on non-slice field %s", field.Name)
+				return
+			}
+			vv.arg = arg
+		default:
+			if n, aerr := strconv.Atoi(arg); aerr != nil || n < 1 {
+				err = fmt.Errorf("invalid arg tag on field %s: %#v", field.Name, arg)
+				return
+			}
+			vv.arg = arg
+		}
+
+		switch reload := field.Tag.Get("reload"); reload {
+		case "", "live":
+		case "restart":
+			vv.restart = true
+		default:
+			err = fmt.Errorf("invalid reload tag on field %s: %#v", field.Name, reload)
+			return
 		}
 
 		if dflt != "" {
@@ -165,7 +289,17 @@ func New(target interface{}, name string) (c configurable.Configurable, err erro
 }
 
 func (v *value) CfSetValue(nw interface{}) error {
-	return coercingSet(v.v, reflect.ValueOf(nw))
+	if s, ok := nw.(string); ok && len(v.enum) > 0 && !contains(v.enum, s) {
+		return fmt.Errorf("invalid value for %s, expecting one of: %s", v.name, strings.Join(v.enum, ", "))
+	}
+
+	err := coercingSet(v.v, reflect.ValueOf(nw))
+	if err != nil && v.secret {
+		// The error may quote the value.
+		return fmt.Errorf("cannot coerce value to type %v", v.v.Type())
+	}
+
+	return err
 }
 
 // Sets a field value to a new value, coercing the new value if necessary.
@@ -226,10 +360,81 @@ func coerceValue(value reflect.Value, oldValue *reflect.Value, targetType reflec
 		return parseString(value.String(), targetType)
 	}
 
+	// Convert between numeric types, e.g. from the int produced by a TOML
+	// decoder to an int64 or float64 field, so long as nothing is lost.
+	if numericKind(value.Kind()) != 0 && numericKind(targetType.Kind()) != 0 && targetType != durationType {
+		return convertNumber(value, targetType)
+	}
+
 	// Don't know how to coerce.
 	return reflect.Value{}, fmt.Errorf("don't know how to coerce %v (%v) to type %v", value.String(), value.Type(), targetType)
 }
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Returns 'i', 'u' or 'f' for signed integer, unsigned integer and floating
+// point kinds respectively, or 0 for other kinds.
+func numericKind(k reflect.Kind) byte {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return 'i'
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return 'u'
+	case reflect.Float32, reflect.Float64:
+		return 'f'
+	default:
+		return 0
+	}
+}
+
+// Converts a number to the given numeric type, failing if the value cannot be
+// represented exactly.
+func convertNumber(value reflect.Value, targetType reflect.Type) (reflect.Value, error) {
+	cv := value.Convert(targetType)
+
+	var exact bool
+	switch numericKind(value.Kind()) {
+	case 'i':
+		n := value.Int()
+		switch numericKind(targetType.Kind()) {
+		case 'i':
+			exact = cv.Int() == n
+		case 'u':
+			exact = n >= 0 && cv.Uint() == uint64(n)
+		case 'f':
+			exact = cv.Float() == float64(n) && float64(n) < math.MaxInt64 && int64(cv.Float()) == n
+		}
+
+	case 'u':
+		n := value.Uint()
+		switch numericKind(targetType.Kind()) {
+		case 'i':
+			exact = cv.Int() >= 0 && uint64(cv.Int()) == n
+		case 'u':
+			exact = cv.Uint() == n
+		case 'f':
+			exact = cv.Float() == float64(n) && float64(n) < math.MaxUint64 && uint64(cv.Float()) == n
+		}
+
+	case 'f':
+		f := value.Float()
+		switch numericKind(targetType.Kind()) {
+		case 'i':
+			exact = f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && float64(cv.Int()) == f
+		case 'u':
+			exact = f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 && float64(cv.Uint()) == f
+		case 'f':
+			exact = cv.Float() == f
+		}
+	}
+
+	if !exact {
+		return reflect.Value{}, fmt.Errorf("value %v cannot be represented exactly as type %v", value.Interface(), targetType)
+	}
+
+	return cv, nil
+}
+
 var re_no = regexp.MustCompile(`(?i)(00*|no?|f(alse)?)`)
 
 // Tries to coerce a string to the specified type.
@@ -267,3 +472,13 @@ func parseString(s string, t reflect.Type) (reflect.Value, error) {
 
 	return reflect.Value{}, fmt.Errorf("cannot coerce string %#v to type %v (%v)", s, t, t.Kind())
 }
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
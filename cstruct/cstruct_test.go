@@ -5,6 +5,7 @@ import "gopkg.in/hlandau/easyconfig.v1/cstruct"
 import "gopkg.in/hlandau/easyconfig.v1/adaptflag"
 import flag "github.com/ogier/pflag"
 import "fmt"
+import "testing"
 
 func Example() {
 	type Config struct {
@@ -24,3 +25,78 @@ func Example() {
 	fmt.Printf("Bar:  %d\n", cfg.Bar)
 	fmt.Printf("Do Stuff: %v\n", cfg.DoStuff)
 }
+
+func ExampleSnapshot() {
+	type Config struct {
+		Bind     string `usage:"Address to bind server to (e.g. :80)" default:":80" reload:"restart"`
+		LogLevel string `usage:"Log level" default:"info"`
+	}
+
+	snap := cstruct.MustNewSnapshot[Config]("snaptest")
+	configurable.Register(snap.Configurable())
+
+	// Configuration is loaded here, e.g. using easyconfig.Configurator.Parse.
+	snap.Publish()
+
+	// Safe to call from any goroutine, even while configuration is reloaded.
+	cfg := snap.Load()
+	fmt.Printf("Bind: %s\n", cfg.Bind)
+	fmt.Printf("Log Level: %s\n", cfg.LogLevel)
+	// Output:
+	// Bind: :80
+	// Log Level: info
+}
+
+type revertConfig struct {
+	Workers  int    `usage:"Number of workers" default:"4"`
+	LogLevel string `usage:"Log level" default:"info"`
+}
+
+func (c *revertConfig) Validate() error {
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be positive")
+	}
+
+	return nil
+}
+
+func TestSnapshotRevert(t *testing.T) {
+	snap := cstruct.MustNewSnapshot[revertConfig]("reverttest")
+	children := snap.Configurable().(interface {
+		CfChildren() []configurable.Configurable
+	}).CfChildren()
+	set := func(i int, x interface{}) {
+		err := children[i].(interface {
+			CfSetValue(x interface{}) error
+		}).CfSetValue(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed reload is not published by the next successful one.
+	set(0, 0)
+	set(1, "debug")
+	if snap.Publish() == nil {
+		t.Fatal("expected validation error")
+	}
+
+	set(1, "warn")
+	err := snap.Publish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := snap.Load(); cfg.Workers != 4 || cfg.LogLevel != "warn" {
+		t.Errorf("unexpected configuration after failed reload: %#v", cfg)
+	}
+
+	set(0, 8)
+	snap.Revert()
+	err = snap.Publish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := snap.Load(); cfg.Workers != 4 {
+		t.Errorf("unexpected configuration after revert: %#v", cfg)
+	}
+}
@@ -0,0 +1,97 @@
+package cstruct
+
+import "fmt"
+import "sync/atomic"
+import "gopkg.in/hlandau/configurable.v1"
+
+// A Snapshot provides concurrent readers with a consistent view of the
+// configuration held in an annotated structure of type T.
+//
+// Configurables created by a Snapshot do not write to a structure which
+// readers can see. Instead, they write to a private staging copy, and once
+// loading or reloading has finished, Publish copies the staging structure
+// and atomically makes the copy available via Load. Readers therefore never
+// observe a partially applied reload.
+//
+// Copies are shallow, so slices in published structures must not be
+// modified.
+type Snapshot[T any] struct {
+	staging *T
+	c       configurable.Configurable
+	cur     atomic.Pointer[T]
+}
+
+// Like NewSnapshot, but panics on failure.
+func MustNewSnapshot[T any](name string) *Snapshot[T] {
+	s, err := NewSnapshot[T](name)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// Creates a Snapshot for the annotated structure type T, using the same rules
+// as New. The returned Snapshot's Configurable must be registered, for example
+// by calling configurable.Register. A structure holding the default values is
+// published immediately.
+func NewSnapshot[T any](name string) (*Snapshot[T], error) {
+	s := &Snapshot[T]{
+		staging: new(T),
+	}
+
+	c, err := New(s.staging, name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.c = c
+	v := *s.staging
+	s.cur.Store(&v)
+	return s, nil
+}
+
+// Returns the group Configurable which sets values on the staging structure.
+func (s *Snapshot[T]) Configurable() configurable.Configurable {
+	return s.c
+}
+
+// Returns the most recently published configuration. The structure returned
+// must not be modified. Safe to call concurrently with Publish.
+func (s *Snapshot[T]) Load() *T {
+	return s.cur.Load()
+}
+
+// Copies the staging structure and publishes the copy, so that subsequent
+// calls to Load return it.
+//
+// If *T has a method Validate() error, it is called on the copy first, and
+// if it returns an error, the copy is not published, the staging structure is
+// reset as by Revert, and the error is returned.
+//
+// Publish must not be called concurrently with values being set, so it
+// should be called once loading or reloading has finished.
+func (s *Snapshot[T]) Publish() error {
+	v := *s.staging
+
+	if vv, ok := interface{}(&v).(interface {
+		Validate() error
+	}); ok {
+		err := vv.Validate()
+		if err != nil {
+			s.Revert()
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+	}
+
+	s.cur.Store(&v)
+	return nil
+}
+
+// Resets the staging structure to the most recently published configuration,
+// discarding values set since, so that they are not published by a later
+// call to Publish. Should be called when loading or reloading fails. Must not
+// be called concurrently with values being set.
+func (s *Snapshot[T]) Revert() {
+	*s.staging = *s.cur.Load()
+}
@@ -2,20 +2,86 @@ package easyconfig // import "gopkg.in/hlandau/easyconfig.v1"
 
 import "os"
 import "fmt"
+import "strings"
+import "sync"
+import "reflect"
+import "path/filepath"
 import "gopkg.in/hlandau/svcutils.v1/exepath"
 import "gopkg.in/hlandau/configurable.v1"
 import "gopkg.in/hlandau/easyconfig.v1/cstruct"
 import "gopkg.in/hlandau/easyconfig.v1/adaptflag"
 import "gopkg.in/hlandau/easyconfig.v1/adaptconf"
 import "gopkg.in/hlandau/easyconfig.v1/adaptenv"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+import "gopkg.in/hlandau/easyconfig.v1/gendoc"
 import "flag"
 
 // Easy configurator. Set the ProgramName and call Parse, passing a pointer to
 // a structure you want to fill with program-specific configuration values.
 type Configurator struct {
-	ProgramName    string
-	configFilePath string
-	inited         bool
+	ProgramName string
+
+	// A one-line description of the program, used in generated documentation
+	// such as the NAME section of man pages. See DocFlag.
+	Description string
+
+	// Determines how keys in configuration files which do not correspond to
+	// any configurable are handled. If left as adaptconf.IgnoreUnknownKeys,
+	// the adaptconf package default is used.
+	UnknownConfigKeys adaptconf.UnknownKeyMode
+
+	// If true, every configuration file found in the standard locations is
+	// loaded, with later files overriding earlier ones, rather than only the
+	// one with the highest precedence. See adaptconf.Layered.
+	LayeredConfig bool
+
+	// The candidate configuration file paths, in order of increasing
+	// precedence. If nil, adaptconf.SearchPaths(ProgramName) is used.
+	ConfigSearchPaths []string
+
+	// Called after each reload triggered by ReloadOn or ReloadOnSIGHUP. If
+	// nil, errors are written to standard error.
+	OnReload func(res *adaptconf.ReloadResult, err error)
+
+	// The name of a flag which, if specified, causes Parse to print each
+	// configurable's effective value and where it came from, then exit. If
+	// empty, "config-explain" is used. If "-", no such flag is registered.
+	ExplainFlag string
+
+	// The name of a flag which, if specified, causes Parse to print a sample
+	// configuration file in TOML format, with every setting commented out and
+	// set to its default value, then exit. If empty, "print-default-config" is
+	// used. If "-", no such flag is registered. See adaptconf.Sample.
+	SampleConfigFlag string
+
+	// The name of a flag which, if specified as e.g. --completion=bash, causes
+	// Parse to print a shell completion script for the given shell, then exit.
+	// The flag is omitted from adaptflag.Usage help output. If empty,
+	// "completion" is used. If "-", no such flag is registered. See
+	// adaptflag.WriteCompletion.
+	CompletionFlag string
+
+	// The name of a flag which, if specified as e.g. --gendoc=markdown, causes
+	// Parse to print reference documentation for every configurable as a man
+	// page ("man") or Markdown ("markdown"), then exit. Like CompletionFlag,
+	// the flag is omitted from help output. If empty, "gendoc" is used. If
+	// "-", no such flag is registered. See the gendoc package.
+	DocFlag string
+
+	// Guards configFilePath and configFilePaths, which Reload may change from
+	// another goroutine; see ReloadOn.
+	mu              sync.Mutex
+	configFilePath  string
+	configFilePaths []string
+	publisher       publisher
+	inited          bool
+}
+
+// Implemented by *cstruct.Snapshot.
+type publisher interface {
+	Configurable() configurable.Configurable
+	Publish() error
+	Revert()
 }
 
 func (cfg *Configurator) Init(tgt interface{}) {
@@ -28,29 +94,230 @@ func (cfg *Configurator) Init(tgt interface{}) {
 
 // Parse configuration values. tgt should be a pointer to a structure to be
 // filled using cstruct. If nil, no structure is registered using cstruct.
+// Positional command line arguments are bound to configurables which receive
+// them, such as fields with an arg tag; see adaptflag.BindArgs. Unless the
+// program has replaced flag.Usage, help output is written by adaptflag.Usage.
+//
+// tgt may instead be a *cstruct.Snapshot, whose name should be the same as
+// ProgramName. In this case the snapshot is published once configuration has
+// been loaded, and again after each successful reload, so that request
+// handlers can safely read the configuration while it is being reloaded.
 func (cfg *Configurator) Parse(tgt interface{}) error {
 	if tgt != nil && cfg.ProgramName != "" {
 		if exepath.ProgramNameSetter == "default" {
 			exepath.ProgramName = cfg.ProgramName
 		}
 
-		configurable.Register(cstruct.MustNew(tgt, cfg.ProgramName))
+		if p, ok := tgt.(publisher); ok {
+			cfg.publisher = p
+			configurable.Register(p.Configurable())
+		} else {
+			c, err := cstruct.New(tgt, cfg.ProgramName)
+			if err != nil {
+				return err
+			}
+			configurable.Register(c)
+		}
+	}
+
+	err := adaptflag.AdaptToFlagSet(flag.CommandLine)
+	if xerr := adaptflag.AdaptGlobalTargets(); err == nil {
+		err = xerr
+	}
+	if err != nil {
+		return err
 	}
 
-	adaptflag.Adapt()
 	adaptenv.Adapt()
+
+	explain := boolFlag(cfg.ExplainFlag, "config-explain", "Print the effective configuration and the source of each value, then exit")
+	sample := boolFlag(cfg.SampleConfigFlag, "print-default-config", "Print a sample configuration file, then exit")
+	completion := choiceFlag(cfg.CompletionFlag, "completion", "Print a shell completion script for bash, zsh or fish, then exit", "bash", "zsh", "fish")
+	doc := choiceFlag(cfg.DocFlag, "gendoc", "Print reference documentation as a man page or Markdown, then exit", "man", "markdown")
+
+	if reflect.ValueOf(flag.Usage).Pointer() == defaultUsage {
+		flag.Usage = adaptflag.Usage(flag.CommandLine)
+	}
+
 	flag.Parse()
+	if sample != nil && *sample {
+		printSample()
+	}
+
+	if completion != nil && completion.value != "" {
+		cfg.printCompletion(completion.value)
+	}
+
+	if doc != nil && doc.value != "" {
+		cfg.printDoc(doc.value)
+	}
+
+	err = adaptflag.BindArgs(flag.Args())
+	if err != nil {
+		return err
+	}
+
+	if cfg.UnknownConfigKeys != adaptconf.IgnoreUnknownKeys {
+		adaptconf.UnknownKeys = cfg.UnknownConfigKeys
+	}
+
+	if cfg.LayeredConfig {
+		adaptconf.Layered = true
+	}
+
 	if cfg.ProgramName != "" {
-		err := adaptconf.Load(cfg.ProgramName)
+		err = adaptconf.LoadPaths(cfg.SearchPaths())
 		if err != nil {
 			return err
 		}
 	}
 
-	cfg.configFilePath = adaptconf.LastConfPath()
+	cfg.setConfigFilePaths()
+
+	if explain != nil && *explain {
+		cfg.explain()
+	}
+
+	if cfg.publisher != nil {
+		return cfg.publisher.Publish()
+	}
+
 	return nil
 }
 
+// The flag package's own usage function, which Parse replaces with
+// adaptflag.Usage unless the program has already replaced it.
+var defaultUsage = reflect.ValueOf(flag.Usage).Pointer()
+
+// Registers a flag with the flag package, unless name is "-" or a flag of
+// that name already exists, in which case nil is returned. If name is empty,
+// dflt is used.
+func boolFlag(name, dflt, usage string) *bool {
+	switch name {
+	case "":
+		name = dflt
+	case "-":
+		return nil
+	}
+
+	if flag.Lookup(name) != nil {
+		return nil
+	}
+
+	return flag.Bool(name, false, usage)
+}
+
+// A hidden flag value which accepts one of a set of choices.
+type choiceValue struct {
+	value   string
+	choices []string
+}
+
+func (v *choiceValue) String() string {
+	return v.value
+}
+
+func (v *choiceValue) Set(s string) error {
+	for _, c := range v.choices {
+		if c == s {
+			v.value = s
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid value %q, expecting one of: %s", s, strings.Join(v.choices, ", "))
+}
+
+func (v *choiceValue) CfHidden() bool {
+	return true
+}
+
+// Like boolFlag, but registers a hidden flag taking one of the given choices.
+func choiceFlag(name, dflt, usage string, choices ...string) *choiceValue {
+	switch name {
+	case "":
+		name = dflt
+	case "-":
+		return nil
+	}
+
+	if flag.Lookup(name) != nil {
+		return nil
+	}
+
+	v := &choiceValue{choices: choices}
+	flag.Var(v, name, usage)
+	return v
+}
+
+func (cfg *Configurator) programName() string {
+	if cfg.ProgramName != "" {
+		return cfg.ProgramName
+	}
+
+	return filepath.Base(os.Args[0])
+}
+
+func (cfg *Configurator) printCompletion(shell string) {
+	err := adaptflag.WriteCompletion(os.Stdout, flag.CommandLine, shell, cfg.programName())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func (cfg *Configurator) printDoc(format string) {
+	var err error
+	switch format {
+	case "man":
+		err = gendoc.Man(os.Stdout, cfg.programName(), cfg.Description)
+	case "markdown":
+		err = gendoc.Markdown(os.Stdout, cfg.programName())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func printSample() {
+	err := adaptconf.Sample(os.Stdout, ".toml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func (cfg *Configurator) explain() {
+	for _, p := range cfg.ConfigFilePaths() {
+		fmt.Printf("# config file: %s\n", p)
+	}
+
+	err := provenance.Explain(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+// Returns the candidate configuration file paths which Parse searches, in
+// order of increasing precedence.
+func (cfg *Configurator) SearchPaths() []string {
+	if cfg.ConfigSearchPaths != nil {
+		return cfg.ConfigSearchPaths
+	}
+
+	return adaptconf.SearchPaths(cfg.ProgramName)
+}
+
 // Like Parse, but exits with an error message if an error occurs.
 func (cfg *Configurator) ParseFatal(tgt interface{}) {
 	err := cfg.Parse(tgt)
@@ -61,10 +328,99 @@ func (cfg *Configurator) ParseFatal(tgt interface{}) {
 }
 
 // After calling Parse successfully, returns the path to the configuration file used, if any.
+//
+// Deprecated: If LayeredConfig is set, more than one file may have been used;
+// use ConfigFilePaths.
 func (cfg *Configurator) ConfigFilePath() string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
 	return cfg.configFilePath
 }
 
+// After calling Parse successfully, returns the paths of all configuration
+// files which contributed to the configuration, in the order they were
+// applied.
+func (cfg *Configurator) ConfigFilePaths() []string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	return append([]string(nil), cfg.configFilePaths...)
+}
+
+// Records the configuration files most recently loaded by adaptconf.
+func (cfg *Configurator) setConfigFilePaths() {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.configFilePath = adaptconf.LastConfPath()
+	cfg.configFilePaths = adaptconf.ConfPaths()
+}
+
+// Reloads configuration files and environment variables after a successful
+// call to Parse. Values are subject to the usual priorities, so values set on
+// the command line are not changed. Settings which require a restart to take
+// effect are not changed, but are listed in the result's RestartRequired if
+// they differ from the configuration in effect. See adaptconf.Reload.
+//
+// If Parse was passed a *cstruct.Snapshot, it is published if the reload
+// succeeds and the configuration passes validation. Otherwise, the reload is
+// undone, so that the values it set are neither published by a later reload
+// nor reported as in effect; see adaptconf.Rollback and
+// cstruct.Snapshot.Revert.
+func (cfg *Configurator) Reload() (*adaptconf.ReloadResult, error) {
+	var res *adaptconf.ReloadResult
+	var err error
+	if cfg.ProgramName != "" {
+		res, err = adaptconf.Reload()
+	}
+
+	restart := adaptenv.Reload()
+	if len(restart) > 0 {
+		if res == nil {
+			res = &adaptconf.ReloadResult{}
+		}
+		res.RestartRequired = append(res.RestartRequired, restart...)
+	}
+
+	if cfg.publisher != nil {
+		if err == nil {
+			err = cfg.publisher.Publish()
+		}
+		if err != nil {
+			adaptconf.Rollback()
+			cfg.publisher.Revert()
+		}
+	}
+
+	if res != nil {
+		cfg.setConfigFilePaths()
+	}
+
+	return res, err
+}
+
+// Calls Reload each time a value is received on ch, until ch is closed. The
+// results are passed to OnReload. Errors do not stop further reloads.
+func (cfg *Configurator) ReloadOn(ch <-chan os.Signal) {
+	go func() {
+		for range ch {
+			res, err := cfg.Reload()
+			if cfg.OnReload != nil {
+				cfg.OnReload(res, err)
+				continue
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot reload configuration: %v\n", err)
+			}
+			if res != nil && len(res.RestartRequired) > 0 {
+				fmt.Fprintf(os.Stderr, "Changes to the following settings require a restart to take effect: %s\n", strings.Join(res.RestartRequired, ", "))
+			}
+		}
+	}()
+}
+
 // Like Configurator.Parse. cfg may be nil.
 func Parse(cfg *Configurator, tgt interface{}) error {
 	if cfg == nil {
@@ -0,0 +1,248 @@
+package easyconfig
+
+import "os"
+import "fmt"
+import "flag"
+import "strings"
+import "testing"
+import "path/filepath"
+import "gopkg.in/hlandau/easyconfig.v1/adaptconf"
+import "gopkg.in/hlandau/easyconfig.v1/cstruct"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+func TestParseShortNameCollision(t *testing.T) {
+	var tgt struct {
+		Bind    string `usage:"Address to bind to" short:"b"`
+		Backlog int    `usage:"Listen backlog" short:"b"`
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"collidetest"}
+
+	cfg := Configurator{ProgramName: "collidetest"}
+	err := cfg.Parse(&tgt)
+	if err == nil || !strings.Contains(err.Error(), "-b") {
+		t.Errorf("expected error for colliding short names, got %v", err)
+	}
+}
+
+func TestParseHelp(t *testing.T) {
+	var tgt struct {
+		Bind    string `usage:"Address to bind to" default:":80"`
+		Verbose bool   `usage:"Verbose output"`
+		Debug   bool   `usage:"Debug mode" hidden:"true"`
+		Listen  string `usage:"Address to listen on" deprecated:"helptest.bind"`
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"helptest", "-h"}
+
+	var b strings.Builder
+	flag.CommandLine.Init("helptest", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(&b)
+	defer flag.CommandLine.Init(args[0], flag.ExitOnError)
+	defer flag.CommandLine.SetOutput(nil)
+
+	cfg := Configurator{ProgramName: "helptest"}
+	err := cfg.Parse(&tgt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.String(), "helptest:\n") || !strings.Contains(b.String(), "--[no-]helptest.verbose") {
+		t.Errorf("help not grouped:\n%s", b.String())
+	}
+	for _, hidden := range []string{"debug", "listen", "completion", "gendoc"} {
+		if strings.Contains(b.String(), hidden) {
+			t.Errorf("help contains hidden flag %s:\n%s", hidden, b.String())
+		}
+	}
+}
+
+type reloadConfig struct {
+	Workers  int    `usage:"Number of workers" default:"4"`
+	LogLevel string `usage:"Log level" default:"info"`
+}
+
+func TestReloadSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reloadtest.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"reloadtest", "--conf=" + path}
+
+	write("[reloadtest]\nworkers = 8\n")
+	snap := cstruct.MustNewSnapshot[reloadConfig]("reloadtest")
+	cfg := Configurator{ProgramName: "reloadtest"}
+	err := cfg.Parse(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values from a failed reload are discarded.
+	write("[reloadtest]\nworkers = \"many\"\nloglevel = \"debug\"\n")
+	_, err = cfg.Reload()
+	if err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+	if c := snap.Load(); c.Workers != 8 || c.LogLevel != "info" {
+		t.Errorf("unexpected configuration after failed reload: %#v", c)
+	}
+
+	// A later successful reload does not publish them.
+	write("[reloadtest]\nworkers = 16\n")
+	_, err = cfg.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := snap.Load(); c.Workers != 16 || c.LogLevel != "info" {
+		t.Errorf("unexpected configuration after reload: %#v", c)
+	}
+
+	// Values discarded after a failed reload are applied once the reload
+	// succeeds.
+	write("[reloadtest]\nworkers = \"many\"\nloglevel = \"warn\"\n")
+	_, err = cfg.Reload()
+	if err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+
+	write("[reloadtest]\nworkers = 32\nloglevel = \"warn\"\n")
+	_, err = cfg.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := snap.Load(); c.Workers != 32 || c.LogLevel != "warn" {
+		t.Errorf("unexpected configuration after reload: %#v", c)
+	}
+}
+
+type validateConfig struct {
+	Workers  int    `usage:"Number of workers" default:"4"`
+	LogLevel string `usage:"Log level" default:"info"`
+}
+
+func (c *validateConfig) Validate() error {
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be positive")
+	}
+
+	return nil
+}
+
+func TestReloadSnapshotValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validatetest.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"validatetest", "--conf=" + path}
+
+	write("[validatetest]\nworkers = 8\n")
+	snap := cstruct.MustNewSnapshot[validateConfig]("validatetest")
+	cfg := Configurator{ProgramName: "validatetest"}
+	err := cfg.Parse(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reload which fails validation is undone entirely.
+	write("[validatetest]\nworkers = 0\nloglevel = \"debug\"\n")
+	_, err = cfg.Reload()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if c := snap.Load(); c.Workers != 8 || c.LogLevel != "info" {
+		t.Errorf("unexpected configuration after failed reload: %#v", c)
+	}
+	if src, _ := provenance.ByName("validatetest.loglevel"); src.Kind != provenance.Default {
+		t.Errorf("rejected value reported as in effect: %#v", src)
+	}
+
+	// Fixing only the invalid value applies the rest again.
+	write("[validatetest]\nworkers = 16\nloglevel = \"debug\"\n")
+	_, err = cfg.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := snap.Load(); c.Workers != 16 || c.LogLevel != "debug" {
+		t.Errorf("unexpected configuration after reload: %#v", c)
+	}
+	if src, _ := provenance.ByName("validatetest.loglevel"); src.Kind != provenance.Config || src.Value != "debug" {
+		t.Errorf("unexpected provenance after reload: %#v", src)
+	}
+}
+
+func TestReloadOn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reloadontest.conf")
+	write := func(s string) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"reloadontest", "--conf=" + path}
+
+	var tgt struct {
+		Workers int `usage:"Number of workers" default:"4"`
+	}
+
+	write("[reloadontest]\nworkers = 8\n")
+	results := make(chan error)
+	cfg := Configurator{
+		ProgramName: "reloadontest",
+		OnReload: func(res *adaptconf.ReloadResult, err error) {
+			results <- err
+		},
+	}
+	err := cfg.Parse(&tgt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan os.Signal)
+	defer close(ch)
+	cfg.ReloadOn(ch)
+
+	write("[reloadontest]\nworkers = 16\n")
+	ch <- os.Interrupt
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	if tgt.Workers != 16 {
+		t.Errorf("unexpected value after reload: %#v", tgt.Workers)
+	}
+
+	// Errors are passed to OnReload, and do not stop further reloads.
+	write("[reloadontest]\nworkers = \"many\"\n")
+	ch <- os.Interrupt
+	if err := <-results; err == nil {
+		t.Error("expected error for invalid value")
+	}
+
+	write("[reloadontest]\nworkers = 32\n")
+	ch <- os.Interrupt
+	paths := cfg.ConfigFilePaths()
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	if tgt.Workers != 32 || len(paths) != 1 || paths[0] != path {
+		t.Errorf("unexpected state after reload: %#v, %v", tgt.Workers, paths)
+	}
+}
@@ -0,0 +1,275 @@
+// Package gendoc generates reference documentation for registered
+// configurables, as man pages or Markdown. Each configurable is documented
+// with its flags, type, default value, environment variable, configuration
+// file key and usage summary line, under a heading naming the group which
+// contains it. Hidden and deprecated configurables are omitted.
+//
+// The documentation can be kept up to date using go generate, for example by
+// running a program which uses the easyconfig package with its --gendoc flag:
+//
+//	//go:generate sh -c "go run . --gendoc=markdown > CONFIG.md"
+//	//go:generate sh -c "go run . --gendoc=man > myprog.1"
+package gendoc
+
+import "fmt"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/adaptflag"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+// The manual section of generated man pages.
+var ManSection = "1"
+
+type entry struct {
+	// The flags which set the configurable, e.g. "-b", "--server.bind".
+	flags []string
+
+	key, typ, dflt, env, usage string
+	enum                       []string
+}
+
+type section struct {
+	heading string
+	entries []entry
+}
+
+// Returns the registered configurables, grouped by the groups which contain
+// them, in the order in which they were registered. Configurables which are
+// not in a group come first, under an empty heading.
+func collect() []section {
+	var sections []section
+	index := map[string]int{}
+	var visit func(path []string, c configurable.Configurable)
+	visit = func(path []string, c configurable.Configurable) {
+		n, ok := name(c)
+		if !ok {
+			return
+		}
+
+		if chs := children(c); len(chs) > 0 {
+			p := append(path[0:len(path):len(path)], n)
+			for _, ch := range chs {
+				visit(p, ch)
+			}
+			return
+		}
+
+		e, ok := newEntry(path, n, c)
+		if !ok {
+			return
+		}
+
+		heading := strings.Join(path, ".")
+		i, ok := index[heading]
+		if !ok {
+			i = len(sections)
+			index[heading] = i
+			sections = append(sections, section{heading: heading})
+		}
+
+		sections[i].entries = append(sections[i].entries, e)
+	}
+
+	configurable.Visit(func(c configurable.Configurable) error {
+		visit(nil, c)
+		return nil
+	})
+
+	if i, ok := index[""]; ok && i > 0 {
+		top := sections[i]
+		sections = append(sections[:i], sections[i+1:]...)
+		sections = append([]section{top}, sections...)
+	}
+
+	return sections
+}
+
+// Describes the configurable with the given name within the group with the
+// given path. ok is false if the configurable cannot be set, or is hidden or
+// deprecated.
+func newEntry(path []string, n string, c configurable.Configurable) (e entry, ok bool) {
+	if _, ok := c.(interface {
+		CfSetValue(x interface{}) error
+	}); !ok {
+		return e, false
+	}
+
+	if _, ok := deprecated(c); ok || hidden(c) {
+		return e, false
+	}
+
+	e = entry{
+		key:  strings.Join(append(path[0:len(path):len(path)], n), "."),
+		env:  envVarName(c),
+		enum: enumValues(c),
+		typ:  typeName(c),
+	}
+	e.usage, _ = usageSummaryLine(c)
+
+	if dflt, ok := defaultValue(c); ok && dflt != nil {
+		e.dflt = fmt.Sprintf("%v", dflt)
+		if provenance.IsSecret(c) && e.dflt != "" {
+			e.dflt = provenance.Redacted
+		}
+	}
+
+	if short := shortName(c); short != 0 {
+		e.flags = append(e.flags, "-"+string(short))
+	}
+	e.flags = append(e.flags, "--"+e.key)
+	for _, alias := range aliases(c) {
+		e.flags = append(e.flags, "--"+alias)
+	}
+	if e.typ == "bool" {
+		e.flags = append(e.flags, "--no-"+e.key)
+	}
+
+	return e, true
+}
+
+// Returns the type of the configurable's value as shown in help output.
+func typeName(c configurable.Configurable) string {
+	v, ok := defaultValue(c)
+	if !ok || v == nil {
+		v = value(c)
+	}
+
+	switch v.(type) {
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case string:
+		return "string"
+	default:
+		return "value"
+	}
+}
+
+func name(c configurable.Configurable) (name string, ok bool) {
+	v, ok := c.(interface {
+		CfName() string
+	})
+	if !ok {
+		return
+	}
+
+	return v.CfName(), true
+}
+
+func children(c configurable.Configurable) []configurable.Configurable {
+	v, ok := c.(interface {
+		CfChildren() []configurable.Configurable
+	})
+	if !ok {
+		return nil
+	}
+
+	return v.CfChildren()
+}
+
+func value(c configurable.Configurable) interface{} {
+	switch v := c.(type) {
+	case interface{ CfValue() interface{} }:
+		return v.CfValue()
+	case interface{ CfGetValue() interface{} }:
+		return v.CfGetValue()
+	default:
+		return nil
+	}
+}
+
+func usageSummaryLine(c configurable.Configurable) (s string, ok bool) {
+	v, ok := c.(interface {
+		CfUsageSummaryLine() string
+	})
+	if !ok {
+		return
+	}
+
+	return v.CfUsageSummaryLine(), true
+}
+
+func hidden(c configurable.Configurable) bool {
+	v, ok := c.(interface {
+		CfHidden() bool
+	})
+
+	return ok && v.CfHidden()
+}
+
+func deprecated(c configurable.Configurable) (replacement string, ok bool) {
+	v, ok := c.(interface {
+		CfDeprecated() (string, bool)
+	})
+	if !ok {
+		return "", false
+	}
+
+	return v.CfDeprecated()
+}
+
+func shortName(c configurable.Configurable) rune {
+	v, ok := c.(interface {
+		CfShortName() rune
+	})
+	if !ok {
+		return 0
+	}
+
+	return v.CfShortName()
+}
+
+func aliases(c configurable.Configurable) []string {
+	v, ok := c.(interface {
+		CfAliases() []string
+	})
+	if !ok {
+		return nil
+	}
+
+	return v.CfAliases()
+}
+
+func envVarName(c configurable.Configurable) string {
+	v, ok := c.(interface {
+		CfEnvVarName() string
+	})
+	if !ok {
+		return ""
+	}
+
+	return v.CfEnvVarName()
+}
+
+func enumValues(c configurable.Configurable) []string {
+	v, ok := c.(interface {
+		CfEnumValues() []string
+	})
+	if !ok {
+		return nil
+	}
+
+	return v.CfEnumValues()
+}
+
+func defaultValue(c configurable.Configurable) (dflt interface{}, ok bool) {
+	v, ok := c.(interface {
+		CfDefaultValue() interface{}
+	})
+	if !ok {
+		return nil, false
+	}
+
+	return v.CfDefaultValue(), true
+}
+
+// Returns the arguments in the synopsis of the program, e.g.
+// "[options] <src>".
+func synopsis() string {
+	if s := adaptflag.Synopsis(); s != "" {
+		return "[options] " + s
+	}
+
+	return "[options]"
+}
@@ -0,0 +1,77 @@
+package gendoc_test
+
+import "os"
+import "strings"
+import "testing"
+import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/cflag"
+import "gopkg.in/hlandau/easyconfig.v1/cstruct"
+import "gopkg.in/hlandau/easyconfig.v1/gendoc"
+
+type serverConfig struct {
+	Bind     string `usage:"Address to bind to" default:":80" env:"SERVER_BIND" short:"b"`
+	Mode     string `usage:"Mode of operation" default:"fast" enum:"fast,slow"`
+	Password string `usage:"Password" default:"hunter2" secret:"true"`
+	Listen   string `usage:"Address to listen on" deprecated:"server.bind"`
+}
+
+func init() {
+	cflag.Bool(nil, "verbose", false, "Verbose output")
+	configurable.Register(cstruct.MustNew(&serverConfig{}, "server"))
+	inner := cflag.NewGroup(cflag.NewGroup(nil, "outer"), "inner")
+	cflag.Int(inner, "depth", 2, "Nesting depth")
+}
+
+func ExampleMarkdown() {
+	gendoc.Markdown(os.Stdout, "myprog")
+
+	// Output:
+	// # myprog
+	//
+	// Usage: `myprog [options]`
+	//
+	// | Flag | Type | Default | Environment | Config key | Description |
+	// | --- | --- | --- | --- | --- | --- |
+	// | `--verbose`, `--no-verbose` | bool | `false` |  | `verbose` | Verbose output |
+	//
+	// ## server
+	//
+	// | Flag | Type | Default | Environment | Config key | Description |
+	// | --- | --- | --- | --- | --- | --- |
+	// | `-b`, `--server.bind` | string | `:80` | `SERVER_BIND` | `server.bind` | Address to bind to |
+	// | `--server.mode` | string | `fast` |  | `server.mode` | Mode of operation (values: `fast`, `slow`) |
+	// | `--server.password` | string | `[redacted]` |  | `server.password` | Password |
+	//
+	// ## outer.inner
+	//
+	// | Flag | Type | Default | Environment | Config key | Description |
+	// | --- | --- | --- | --- | --- | --- |
+	// | `--outer.inner.depth` | int | `2` |  | `outer.inner.depth` | Nesting depth |
+}
+
+func TestMan(t *testing.T) {
+	var b strings.Builder
+	err := gendoc.Man(&b, "myprog", "does things")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{
+		".TH \"MYPROG\" \"1\"\n",
+		"myprog \\- does things\n",
+		".SS \"server\"\n",
+		"\\fB\\-b\\fR, \\fB\\-\\-server.bind\\fR=\\fIstring\\fR\n",
+		"Config key: server.bind. Default: :80. Environment: SERVER_BIND.\n",
+		".SH ENVIRONMENT\n.TP\n.B SERVER_BIND\n",
+		".SS \"outer.inner\"\n",
+		"Config key: outer.inner.depth. Default: 2.\n",
+	} {
+		if !strings.Contains(b.String(), part) {
+			t.Errorf("man page does not contain %q:\n%s", part, b.String())
+		}
+	}
+
+	if strings.Contains(b.String(), "listen") || strings.Contains(b.String(), "hunter2") {
+		t.Errorf("man page contains deprecated or secret configurable:\n%s", b.String())
+	}
+}
@@ -0,0 +1,98 @@
+package gendoc
+
+import "fmt"
+import "io"
+import "strings"
+
+// Writes a man page in roff format for the registered configurables to w, in
+// section ManSection. description is a one-line description of the program
+// shown in the NAME section, and may be empty. Each group is a subsection of
+// OPTIONS, and environment variables are also listed under ENVIRONMENT.
+func Man(w io.Writer, program, description string) error {
+	sections := collect()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ".TH %s %s\n", roffQuote(strings.ToUpper(program)), roffQuote(ManSection))
+	b.WriteString(".SH NAME\n")
+	if description != "" {
+		fmt.Fprintf(&b, "%s \\- %s\n", roffEscape(program), roffEscape(description))
+	} else {
+		fmt.Fprintf(&b, "%s\n", roffEscape(program))
+	}
+
+	b.WriteString(".SH SYNOPSIS\n")
+	fmt.Fprintf(&b, "\\fB%s\\fR %s\n", roffEscape(program), roffEscape(synopsis()))
+
+	var env []entry
+	b.WriteString(".SH OPTIONS\n")
+	for _, s := range sections {
+		if s.heading != "" {
+			fmt.Fprintf(&b, ".SS %s\n", roffQuote(s.heading))
+		}
+
+		for _, e := range s.entries {
+			flags := make([]string, len(e.flags))
+			for i, f := range e.flags {
+				flags[i] = "\\fB" + roffEscape(f) + "\\fR"
+			}
+
+			b.WriteString(".TP\n")
+			b.WriteString(strings.Join(flags, ", "))
+			if e.typ != "bool" {
+				fmt.Fprintf(&b, "=\\fI%s\\fR", roffEscape(e.typ))
+			}
+			b.WriteString("\n")
+
+			if e.usage != "" {
+				fmt.Fprintf(&b, "%s\n.br\n", roffEscape(e.usage))
+			}
+
+			details := []string{"Config key: " + e.key + "."}
+			if e.dflt != "" {
+				details = append(details, "Default: "+e.dflt+".")
+			}
+			if e.env != "" {
+				details = append(details, "Environment: "+e.env+".")
+				env = append(env, e)
+			}
+			if len(e.enum) > 0 {
+				details = append(details, "Values: "+strings.Join(e.enum, ", ")+".")
+			}
+			fmt.Fprintf(&b, "%s\n", roffEscape(strings.Join(details, " ")))
+		}
+	}
+
+	if len(env) > 0 {
+		b.WriteString(".SH ENVIRONMENT\n")
+		for _, e := range env {
+			fmt.Fprintf(&b, ".TP\n.B %s\n", roffEscape(e.env))
+			if e.usage != "" {
+				fmt.Fprintf(&b, "%s\n", roffEscape(e.usage))
+			}
+			fmt.Fprintf(&b, "Overrides %s.\n", roffEscape(e.key))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var roffEscaper = strings.NewReplacer(`\`, `\e`, `-`, `\-`)
+
+// Escapes s for use in running text. Lines which would otherwise be
+// interpreted as requests are protected.
+func roffEscape(s string) string {
+	lines := strings.Split(roffEscaper.Replace(strings.TrimSpace(s)), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Quotes s as an argument to a request.
+func roffQuote(s string) string {
+	return `"` + strings.Replace(roffEscape(s), `"`, `""`, -1) + `"`
+}
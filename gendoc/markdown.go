@@ -0,0 +1,66 @@
+package gendoc
+
+import "fmt"
+import "io"
+import "strings"
+
+// Writes a Markdown reference for the registered configurables to w, titled
+// with the program name. Each group is a section containing a table with a
+// row for each configurable.
+func Markdown(w io.Writer, program string) error {
+	sections := collect()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", program)
+	fmt.Fprintf(&b, "Usage: %s\n", mdCode(program+" "+synopsis()))
+	for _, s := range sections {
+		b.WriteString("\n")
+		if s.heading != "" {
+			fmt.Fprintf(&b, "## %s\n\n", mdEscape(s.heading))
+		}
+
+		b.WriteString("| Flag | Type | Default | Environment | Config key | Description |\n")
+		b.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+		for _, e := range s.entries {
+			flags := make([]string, len(e.flags))
+			for i, f := range e.flags {
+				flags[i] = mdCode(f)
+			}
+
+			desc := mdEscape(e.usage)
+			if len(e.enum) > 0 {
+				values := make([]string, len(e.enum))
+				for i, v := range e.enum {
+					values[i] = mdCode(v)
+				}
+				desc = strings.TrimSpace(desc + " (values: " + strings.Join(values, ", ") + ")")
+			}
+
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n",
+				strings.Join(flags, ", "), e.typ, mdCode(e.dflt), mdCode(e.env), mdCode(e.key), desc)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var mdEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", `*`, `\*`, `_`, `\_`, "`", "\\`")
+
+func mdEscape(s string) string {
+	return mdEscaper.Replace(strings.TrimSpace(s))
+}
+
+// Formats s as a code span within a table cell, or returns "" if s is empty.
+func mdCode(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	s = strings.Replace(strings.Replace(s, "\n", " ", -1), "|", `\|`, -1)
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+
+	return "`" + s + "`"
+}
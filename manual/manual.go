@@ -2,6 +2,7 @@
 package manual
 
 import "gopkg.in/hlandau/configurable.v1"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
 import "strings"
 import "fmt"
 
@@ -75,21 +76,35 @@ func set(c configurable.Configurable, s interface{}) error {
 		CfSetPriority(priority configurable.Priority)
 	})
 	if !ok {
-		return cs.CfSetValue(s)
+		err := cs.CfSetValue(s)
+		record(c, s, err == nil)
+		return err
 	}
 
 	if cp.CfGetPriority() <= configurable.FlagPriority {
 		err := cs.CfSetValue(s)
 		if err != nil {
+			record(c, s, false)
 			return err
 		}
 
 		cp.CfSetPriority(configurable.FlagPriority)
+		record(c, s, true)
+	} else {
+		record(c, s, false)
 	}
 
 	return nil
 }
 
+func record(c configurable.Configurable, v interface{}, applied bool) {
+	provenance.Record(c, provenance.Source{
+		Kind:    provenance.Manual,
+		Value:   v,
+		Applied: applied,
+	})
+}
+
 func getName(c configurable.Configurable) (name string, ok bool) {
 	v, ok := c.(interface {
 		CfName() string
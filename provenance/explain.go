@@ -0,0 +1,119 @@
+package provenance
+
+import "fmt"
+import "io"
+import "strings"
+import "gopkg.in/hlandau/configurable.v1"
+
+// Writes a description of every registered configurable which has a value to
+// w: its dotted path, effective value and default value, the source of the
+// effective value, and any values supplied by other sources which did not
+// take effect or were later overridden. The values of secret configurables are
+// redacted.
+func Explain(w io.Writer) error {
+	var err error
+	configurable.Visit(func(c configurable.Configurable) error {
+		err = explainRecursive(w, nil, c)
+		return err
+	})
+
+	return err
+}
+
+func explainRecursive(w io.Writer, path []string, c configurable.Configurable) error {
+	n, ok := getName(c)
+	if ok {
+		path = append(path[0:len(path):len(path)], n)
+	}
+
+	cc, ok := c.(interface {
+		CfChildren() []configurable.Configurable
+	})
+	if ok {
+		for _, ch := range cc.CfChildren() {
+			err := explainRecursive(w, path, ch)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return explain(w, strings.Join(path, "."), c)
+}
+
+func explain(w io.Writer, name string, c configurable.Configurable) error {
+	v, ok := value(c)
+	if !ok {
+		return nil
+	}
+
+	if IsSecret(c) && v != "" {
+		v = Redacted
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s = %#v\n", name, v)
+	if dflt, ok := defaultValue(c); ok {
+		if IsSecret(c) && dflt != "" {
+			dflt = Redacted
+		}
+		fmt.Fprintf(&b, "  default:    %#v\n", dflt)
+	}
+
+	// The winning source is the last one which took effect; everything else
+	// was overridden.
+	h := History(c)
+	win := -1
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Applied {
+			win = i
+			break
+		}
+	}
+
+	if win < 0 {
+		fmt.Fprintf(&b, "  source:     %v\n", Source{Kind: Default})
+	} else {
+		fmt.Fprintf(&b, "  source:     %v\n", h[win])
+	}
+
+	for i, src := range h {
+		if i == win {
+			continue
+		}
+
+		fmt.Fprintf(&b, "  overridden: %#v from %v\n", src.Value, src)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func value(c configurable.Configurable) (v interface{}, ok bool) {
+	if cv, ok := c.(interface {
+		CfValue() interface{}
+	}); ok {
+		return cv.CfValue(), true
+	}
+
+	if cv, ok := c.(interface {
+		CfGetValue() interface{}
+	}); ok {
+		return cv.CfGetValue(), true
+	}
+
+	return nil, false
+}
+
+func defaultValue(c configurable.Configurable) (dflt interface{}, ok bool) {
+	v, ok := c.(interface {
+		CfDefaultValue() interface{}
+	})
+	if !ok {
+		return nil, false
+	}
+
+	return v.CfDefaultValue(), true
+}
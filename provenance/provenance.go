@@ -0,0 +1,245 @@
+// Package provenance records where the values of configurables came from.
+//
+// The adaptflag, adaptenv, adaptconf and manual packages record a Source each
+// time they supply a value for a configurable, whether or not the value takes
+// effect. This makes it possible to answer the question "where did this value
+// come from?", and to see which values were overridden by higher priority
+// sources.
+package provenance
+
+import "fmt"
+import "log"
+import "sync"
+import "strings"
+import "time"
+import "gopkg.in/hlandau/configurable.v1"
+
+// The kind of source a value came from.
+type Kind int
+
+const (
+	// The configurable's default value.
+	Default Kind = iota
+
+	// A command line flag.
+	Flag
+
+	// An environment variable.
+	Env
+
+	// A configuration file.
+	Config
+
+	// Set programmatically, for example using the manual package.
+	Manual
+
+	// A positional command line argument.
+	Arg
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Default:
+		return "default"
+	case Flag:
+		return "flag"
+	case Env:
+		return "environment"
+	case Config:
+		return "config"
+	case Manual:
+		return "manual"
+	case Arg:
+		return "argument"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Describes a value supplied for a configurable.
+type Source struct {
+	Kind Kind
+
+	// For Flag, the flag as spelt on the command line, e.g. "--bind" or "-b".
+	// For Env, the name of the environment variable. For Config, the path of
+	// the file. For Arg, the position of the argument, counting from 1.
+	// Otherwise empty.
+	Location string
+
+	// For Config, the line number within the file, or 0 if unknown.
+	Line int
+
+	// The value as supplied, before any conversion by the configurable.
+	Value interface{}
+
+	// Whether the value took effect. A value does not take effect if a higher
+	// priority source has already set the configurable, or if the configurable
+	// rejected it.
+	Applied bool
+
+	// When the value was supplied.
+	Time time.Time
+}
+
+// Describes the source in a form suitable for showing to an operator, e.g.
+// "config file /etc/foo.conf:12".
+func (s Source) String() string {
+	switch s.Kind {
+	case Default:
+		return "default"
+	case Flag:
+		return fmt.Sprintf("flag %s", s.Location)
+	case Env:
+		return fmt.Sprintf("environment variable %s", s.Location)
+	case Config:
+		if s.Line > 0 {
+			return fmt.Sprintf("config file %s:%d", s.Location, s.Line)
+		}
+		return fmt.Sprintf("config file %s", s.Location)
+	case Manual:
+		return "set programmatically"
+	case Arg:
+		return fmt.Sprintf("command line argument %s", s.Location)
+	default:
+		return s.Kind.String()
+	}
+}
+
+// Called by the adaptflag, adaptenv and adaptconf packages to emit warnings,
+// for example when a deprecated flag is used or a configuration file contains
+// unknown keys. Defaults to log.Printf.
+var Warnf func(format string, args ...interface{}) = log.Printf
+
+// The number of sources retained for each configurable.
+const maxHistory = 32
+
+var mu sync.Mutex
+var history = map[configurable.Configurable][]Source{}
+
+// Records that a value has been supplied for a configurable. If src.Time is
+// zero, the current time is used. If the configurable is secret, the value is
+// not retained.
+func Record(c configurable.Configurable, src Source) {
+	if src.Time.IsZero() {
+		src.Time = time.Now()
+	}
+
+	if IsSecret(c) {
+		src.Value = Redacted
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	h := append(history[c], src)
+	if len(h) > maxHistory {
+		h = h[len(h)-maxHistory:]
+	}
+	history[c] = h
+}
+
+// Returns the source of the configurable's current value. If no value which
+// took effect has been recorded, the configurable has its default value and a
+// Source of kind Default is returned.
+func Get(c configurable.Configurable) Source {
+	mu.Lock()
+	defer mu.Unlock()
+
+	h := history[c]
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Applied {
+			return h[i]
+		}
+	}
+
+	return Source{Kind: Default, Applied: true}
+}
+
+// Returns every value recorded for the configurable, in the order in which
+// they were supplied, including those which did not take effect.
+func History(c configurable.Configurable) []Source {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]Source(nil), history[c]...)
+}
+
+// Replaces the sources recorded for the configurable with h, as previously
+// returned by History, for example to discard values which were rolled back.
+func Reset(c configurable.Configurable, h []Source) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(h) == 0 {
+		delete(history, c)
+		return
+	}
+
+	history[c] = append([]Source(nil), h...)
+}
+
+// Like Get, but finds the configurable by its dotted path, e.g.
+// "example.bind". Returns false if there is no such configurable.
+func ByName(name string) (Source, bool) {
+	c := byName(name)
+	if c == nil {
+		return Source{}, false
+	}
+
+	return Get(c), true
+}
+
+func byName(name string) configurable.Configurable {
+	var cs []configurable.Configurable
+	configurable.Visit(func(c configurable.Configurable) error {
+		cs = append(cs, c)
+		return nil
+	})
+
+	var c configurable.Configurable
+	for _, n := range strings.Split(name, ".") {
+		c = nil
+		for _, ch := range cs {
+			if cn, ok := getName(ch); ok && cn == n {
+				c = ch
+				break
+			}
+		}
+		if c == nil {
+			return nil
+		}
+
+		cs = nil
+		if cc, ok := c.(interface {
+			CfChildren() []configurable.Configurable
+		}); ok {
+			cs = cc.CfChildren()
+		}
+	}
+
+	return c
+}
+
+// Shown in place of the values of secret configurables.
+const Redacted = "[redacted]"
+
+// Reports whether the configurable's value must not be shown, as indicated by
+// a method CfSecret() bool.
+func IsSecret(c configurable.Configurable) bool {
+	v, ok := c.(interface {
+		CfSecret() bool
+	})
+
+	return ok && v.CfSecret()
+}
+
+func getName(c configurable.Configurable) (name string, ok bool) {
+	v, ok := c.(interface {
+		CfName() string
+	})
+	if !ok {
+		return
+	}
+
+	return v.CfName(), true
+}
@@ -0,0 +1,31 @@
+package provenance_test
+
+import "os"
+import "gopkg.in/hlandau/easyconfig.v1/cflag"
+import "gopkg.in/hlandau/easyconfig.v1/manual"
+import "gopkg.in/hlandau/easyconfig.v1/provenance"
+
+func ExampleExplain() {
+	g := cflag.NewGroup(nil, "explainexample")
+	cflag.String(g, "bind", ":80", "Address to bind to")
+	cflag.Int(g, "workers", 4, "Number of workers")
+	cflag.Secret(g, "password", "", "Password")
+
+	manual.Set("explainexample.bind", ":8080")
+	manual.Set("explainexample.bind", ":9090")
+	manual.Set("explainexample.password", "hunter2")
+
+	provenance.Explain(os.Stdout)
+
+	// Output:
+	// explainexample.bind = ":9090"
+	//   default:    ":80"
+	//   source:     set programmatically
+	//   overridden: ":8080" from set programmatically
+	// explainexample.workers = 4
+	//   default:    4
+	//   source:     default
+	// explainexample.password = "[redacted]"
+	//   default:    ""
+	//   source:     set programmatically
+}
@@ -0,0 +1,21 @@
+//go:build !js && !plan9 && !wasip1
+// +build !js,!plan9,!wasip1
+
+package easyconfig
+
+import "os"
+import "syscall"
+import "os/signal"
+
+// Calls Reload each time the process receives SIGHUP. Returns a function
+// which stops reloading on SIGHUP.
+func (cfg *Configurator) ReloadOnSIGHUP() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	cfg.ReloadOn(ch)
+
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
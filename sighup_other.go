@@ -0,0 +1,10 @@
+//go:build js || plan9 || wasip1
+// +build js plan9 wasip1
+
+package easyconfig
+
+// SIGHUP does not exist on this platform, so this does nothing. Use ReloadOn
+// to reload on some other event.
+func (cfg *Configurator) ReloadOnSIGHUP() (stop func()) {
+	return func() {}
+}